@@ -1,6 +1,9 @@
 package database
 
 import (
+	"fmt"
+
+	"golang.org/x/net/context"
 	mgo "gopkg.in/mgo.v2"
 )
 
@@ -16,14 +19,39 @@ func (ms *Mongo) Query(collection string, f func(c *mgo.Collection) error) error
 	return f(c)
 }
 
+// mgo pings without a context, ctx only bounds the wait
+func (ms *Mongo) HealthCheck(ctx context.Context) error {
+	s := ms.Session.Copy()
+	pinged := make(chan error, 1)
+	go func() {
+		defer s.Close()
+		pinged <- s.Ping()
+	}()
+	select {
+	case err := <-pinged:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (ms *Mongo) Close() error { ms.Session.Close(); return nil }
 
-func NewMongo(url, db string) *Mongo {
-	s, err := mgo.Dial(url)
+func NewMongo(url, db string) (*Mongo, error) {
+	return NewMongoWith(url, db, Options{})
+}
+
+// MaxOpenConns limits sockets per server if set, mgo's own limit otherwise, SSLMode and Params don't apply,
+// mgo takes its options in url
+func NewMongoWith(url, db string, opts Options) (*Mongo, error) {
+	s, err := mgo.DialWithTimeout(url, opts.connectTimeout())
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("connect ( mongo ) failed ( %s )", err.Error())
 	}
 	s.SetSafe(&mgo.Safe{})
 	s.SetMode(mgo.Monotonic, true)
-	return &Mongo{s, db}
+	if opts.MaxOpenConns > 0 {
+		s.SetPoolLimit(opts.MaxOpenConns)
+	}
+	return &Mongo{s, db}, nil
 }
@@ -2,33 +2,44 @@ package database
 
 import (
 	"database/sql"
-	"fmt"
+	"net"
 
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/net/context"
 )
 
 type Mysql struct {
 	db *sql.DB
 }
 
-func (m *Mysql) ensureConnection() *Mysql {
-	if err := m.db.Ping(); err != nil {
-		panic(err)
+// connections are checked as they're taken from the pool, HealthCheck to probe it on purpose
+func (m *Mysql) Query(f func(db *sql.DB) error) error  { return f(m.db) }
+func (m *Mysql) HealthCheck(ctx context.Context) error { return m.db.PingContext(ctx) }
+func (m *Mysql) Close() error                          { return m.db.Close() }
+
+func mysqlDSN(host, port, user, password, db string, opts Options) string {
+	cfg := mysql.NewConfig()
+	cfg.User, cfg.Passwd, cfg.DBName = user, password, db
+	cfg.Net, cfg.Addr = "tcp", net.JoinHostPort(host, port)
+	cfg.Timeout = opts.connectTimeout()
+	if opts.SSLMode != "" && opts.SSLMode != "disable" {
+		cfg.TLSConfig = opts.SSLMode
+	}
+	cfg.Params = map[string]string{"charset": "utf8"}
+	for _, k := range keysOf(opts.Params) {
+		cfg.Params[k] = opts.Params[k]
 	}
-	return m
+	return cfg.FormatDSN()
 }
 
-func (m *Mysql) Query(f func(db *sql.DB) error) error { return f(m.ensureConnection().db) }
-func (m *Mysql) Close() error                         { return m.db.Close() }
+func NewMysql(host, port, user, password, db string) (*Mysql, error) {
+	return NewMysqlWith(host, port, user, password, db, Options{})
+}
 
-func NewMysql(host, port, user, password, db string) *Mysql {
-	mySqlInfo := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?charset=utf8",
-		user, password, host, port, db)
-	if db, err := sql.Open("mysql", mySqlInfo); err != nil {
-		panic(err)
+func NewMysqlWith(host, port, user, password, db string, opts Options) (*Mysql, error) {
+	if d, err := open("mysql", mysqlDSN(host, port, user, password, db, opts), opts); err != nil {
+		return nil, err
 	} else {
-		db.SetMaxOpenConns(50)
-		db.SetMaxIdleConns(50)
-		return (&(Mysql{db})).ensureConnection()
+		return &Mysql{d}, nil
 	}
 }
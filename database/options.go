@@ -0,0 +1,79 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+/////////////////////////////////////////////////////////////////////////
+// Options, zero values fall back to defaults, Params go into the DSN //
+// as they are and win over anything Options sets there              //
+type Options struct {
+	MaxOpenConns    int           // 50 by default, mgo's own limit for mongo
+	MaxIdleConns    int           // 50 by default, no more than MaxOpenConns
+	ConnMaxLifetime time.Duration // reused forever by default
+	ConnectTimeout  time.Duration // 10s by default
+	SSLMode         string        // postgres sslmode, or mysql tls e.g. true, skip-verify, disabled by default
+	Params          map[string]string
+}
+
+func (o Options) maxOpenConns() int {
+	if o.MaxOpenConns > 0 {
+		return o.MaxOpenConns
+	}
+	return 50
+}
+
+func (o Options) maxIdleConns() int {
+	if o.MaxIdleConns > 0 && o.MaxIdleConns < o.maxOpenConns() {
+		return o.MaxIdleConns
+	}
+	return o.maxOpenConns()
+}
+
+func (o Options) connectTimeout() time.Duration {
+	if o.ConnectTimeout > 0 {
+		return o.ConnectTimeout
+	}
+	return 10 * time.Second
+}
+
+// sorted, DSNs come out the same every time
+func keysOf(params map[string]string) (keys []string) {
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return
+}
+
+// pooled as o tells, pinged once within the connect timeout
+func open(driver, dsn string, o Options) (*sql.DB, error) {
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("open ( %s ) failed ( %s )", driver, err.Error())
+	}
+	db.SetMaxOpenConns(o.maxOpenConns())
+	db.SetMaxIdleConns(o.maxIdleConns())
+	db.SetConnMaxLifetime(o.ConnMaxLifetime)
+	ctx, cancel := context.WithTimeout(context.Background(), o.connectTimeout())
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("connect ( %s ) failed ( %s )", driver, err.Error())
+	}
+	return db, nil
+}
+
+// key=value of libpq, values quoted for spaces & quotes
+func quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, ` '\`) {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
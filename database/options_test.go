@@ -0,0 +1,33 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsDefaults(t *testing.T) {
+	assert.Equal(t, 50, Options{}.maxOpenConns())
+	assert.Equal(t, 50, Options{}.maxIdleConns())
+	assert.Equal(t, 10, Options{MaxOpenConns: 10, MaxIdleConns: 20}.maxIdleConns())
+	assert.Equal(t, 10*time.Second, Options{}.connectTimeout())
+}
+
+func TestMysqlDSN(t *testing.T) {
+	assert.Equal(t, "lucy:p@ss@tcp(localhost:3306)/db?timeout=10s&charset=utf8",
+		mysqlDSN("localhost", "3306", "lucy", "p@ss", "db", Options{}))
+	assert.Equal(t, "lucy:p@tcp(localhost:3306)/db?timeout=3s&tls=skip-verify&charset=utf8mb4&parseTime=true",
+		mysqlDSN("localhost", "3306", "lucy", "p", "db", Options{ConnectTimeout: 3 * time.Second,
+			SSLMode: "skip-verify", Params: map[string]string{"charset": "utf8mb4", "parseTime": "true"}}))
+}
+
+func TestPostgresDSN(t *testing.T) {
+	assert.Equal(t, "connect_timeout=10 dbname=db host=localhost password='it\\'s a secret' port=5432 "+
+		"sslmode=disable user=lucy",
+		postgresDSN("localhost", "5432", "db", "lucy", "it's a secret", Options{}))
+	assert.Equal(t, "application_name=bolsa connect_timeout=1 dbname=db host=localhost password=p port=5432 "+
+		"sslmode=verify-full user=lucy",
+		postgresDSN("localhost", "5432", "db", "lucy", "p", Options{ConnectTimeout: 500 * time.Millisecond,
+			SSLMode: "verify-full", Params: map[string]string{"application_name": "bolsa"}}))
+}
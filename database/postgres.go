@@ -3,32 +3,53 @@ package database
 import (
 	"database/sql"
 	"fmt"
+	"math"
+	"strings"
 
 	_ "github.com/lib/pq"
+	"golang.org/x/net/context"
 )
 
 type Postgres struct {
 	db *sql.DB
 }
 
-func (p *Postgres) ensureConnection() *Postgres {
-	if err := p.db.Ping(); err != nil {
-		panic(err)
+// connections are checked as they're taken from the pool, HealthCheck to probe it on purpose
+func (p *Postgres) Query(f func(db *sql.DB) error) error  { return f(p.db) }
+func (p *Postgres) HealthCheck(ctx context.Context) error { return p.db.PingContext(ctx) }
+func (p *Postgres) Close() error                          { return p.db.Close() }
+
+func postgresDSN(host, port, db, user, password string, opts Options) string {
+	params := map[string]string{
+		"host":            host,
+		"port":            port,
+		"user":            user,
+		"dbname":          db,
+		"password":        password,
+		"sslmode":         "disable",
+		"connect_timeout": fmt.Sprintf("%d", int(math.Ceil(opts.connectTimeout().Seconds()))),
+	}
+	if opts.SSLMode != "" {
+		params["sslmode"] = opts.SSLMode
+	}
+	for k := range opts.Params {
+		params[k] = opts.Params[k]
 	}
-	return p
+	pairs := make([]string, 0, len(params))
+	for _, k := range keysOf(params) {
+		pairs = append(pairs, k+"="+quote(params[k]))
+	}
+	return strings.Join(pairs, " ")
 }
 
-func (p *Postgres) Query(f func(db *sql.DB) error) error { return f(p.ensureConnection().db) }
-func (p *Postgres) Close() error                         { return p.db.Close() }
+func NewPostgres(host, port, db, user, password string) (*Postgres, error) {
+	return NewPostgresWith(host, port, db, user, password, Options{})
+}
 
-func NewPostgres(host, port, db, user, password string) *Postgres {
-	psqlInfo := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s",
-		host, port, user, db, password)
-	if d, err := sql.Open("postgres", psqlInfo); err != nil {
-		panic(err)
+func NewPostgresWith(host, port, db, user, password string, opts Options) (*Postgres, error) {
+	if d, err := open("postgres", postgresDSN(host, port, db, user, password, opts), opts); err != nil {
+		return nil, err
 	} else {
-		d.SetMaxOpenConns(50)
-		d.SetMaxIdleConns(50)
-		return (&Postgres{d}).ensureConnection()
+		return &Postgres{d}, nil
 	}
 }
@@ -0,0 +1,97 @@
+package dedup
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/samwooo/bolsa/database"
+	"github.com/samwooo/bolsa/job/dedup/imp"
+	"github.com/samwooo/bolsa/logging"
+)
+
+////////////////
+// Dedup IMP //
+type dedupImp interface {
+	Name() string
+	DoClaim(key string, ttl time.Duration) (bool, error) // true if key not seen within ttl
+	DoRelease(key string) error                          // forget key so it can be claimed again
+}
+
+//////////////////////////////////////////////////////////////
+// Idempotency Key, ok = false means data never gets dedup //
+type Key func(data interface{}) (key string, ok bool)
+
+////////////////
+// Job Dedup //
+type Dedup struct {
+	logger logging.Logger
+	key    Key
+	ttl    time.Duration
+	dedupImp
+}
+
+func (dd *Dedup) Name() string {
+	if dd.dedupImp != nil {
+		return dd.dedupImp.Name()
+	} else {
+		return fmt.Sprintf("default")
+	}
+}
+func (dd *Dedup) TTL() time.Duration { return dd.ttl }
+
+// fail open, a broken store should never stop a job
+func (dd *Dedup) Claim(data interface{}) bool {
+	if dd.key == nil || dd.dedupImp == nil {
+		return true
+	} else if key, ok := dd.key(data); !ok {
+		return true
+	} else if fresh, err := dd.dedupImp.DoClaim(key, dd.ttl); err != nil {
+		dd.logger.Warnf("✗ dedup %s claim ( %s ) failed ( %s )", dd.Name(), key, err.Error())
+		return true
+	} else {
+		dd.logger.Debugf("✔ dedup %s claim ( %s, %t )", dd.Name(), key, fresh)
+		return fresh
+	}
+}
+func (dd *Dedup) Release(data interface{}) {
+	if dd.key != nil && dd.dedupImp != nil {
+		if key, ok := dd.key(data); ok {
+			if err := dd.dedupImp.DoRelease(key); err != nil {
+				dd.logger.Warnf("✗ dedup %s release ( %s ) failed ( %s )", dd.Name(), key, err.Error())
+			} else {
+				dd.logger.Debugf("✔ dedup %s release ( %s )", dd.Name(), key)
+			}
+		}
+	}
+}
+
+func newDedup(logger logging.Logger, key Key, ttl time.Duration, d dedupImp) *Dedup {
+	if key == nil {
+		panic("unable to initialise a dedup without a key!")
+	} else {
+		return &Dedup{logger, key, ttl, d}
+	}
+}
+
+func NewLRUDedup(name string, key Key, ttl time.Duration, capacity int) *Dedup {
+	return newDedup(logging.GetLogger(" "+name+" "), key, ttl, imp.NewLRUDedupImp(capacity))
+}
+
+// keys are stored as VARCHAR(255), longer ones get rejected by postgres
+func NewPostgresDedup(name string, key Key, ttl time.Duration, db *database.Postgres,
+	table string) (*Dedup, error) {
+	if sd, err := imp.NewSQLDedupImp(db, imp.Postgres, table); err != nil {
+		return nil, err
+	} else {
+		return newDedup(logging.GetLogger(" "+name+" "), key, ttl, sd), nil
+	}
+}
+
+// keys are stored as VARCHAR(255), longer ones get rejected or truncated by mysql depending on sql_mode
+func NewMysqlDedup(name string, key Key, ttl time.Duration, db *database.Mysql, table string) (*Dedup, error) {
+	if sd, err := imp.NewSQLDedupImp(db, imp.Mysql, table); err != nil {
+		return nil, err
+	} else {
+		return newDedup(logging.GetLogger(" "+name+" "), key, ttl, sd), nil
+	}
+}
@@ -0,0 +1,56 @@
+package dedup
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ = logging.DefaultLogger("", logging.LogLevelFrom("ERROR"), 100)
+
+var keyOf = func(data interface{}) (string, bool) {
+	if data == nil {
+		return "", false
+	} else {
+		return fmt.Sprintf("%+v", data), true
+	}
+}
+
+func TestLRUDedupClaim(t *testing.T) {
+	dd := NewLRUDedup("", keyOf, time.Minute, 10)
+	assert.Equal(t, true, dd.Claim(1))
+	assert.Equal(t, false, dd.Claim(1))
+	assert.Equal(t, true, dd.Claim(2))
+	assert.Equal(t, true, dd.Claim(nil))
+	assert.Equal(t, true, dd.Claim(nil))
+}
+
+func TestLRUDedupRelease(t *testing.T) {
+	dd := NewLRUDedup("", keyOf, time.Minute, 10)
+	assert.Equal(t, true, dd.Claim(1))
+	dd.Release(1)
+	assert.Equal(t, true, dd.Claim(1))
+	assert.Equal(t, false, dd.Claim(1))
+}
+
+func TestLRUDedupTTL(t *testing.T) {
+	dd := NewLRUDedup("", keyOf, time.Millisecond*50, 10)
+	assert.Equal(t, true, dd.Claim(1))
+	assert.Equal(t, false, dd.Claim(1))
+	time.Sleep(time.Millisecond * 100)
+	assert.Equal(t, true, dd.Claim(1))
+}
+
+func TestLRUDedupCapacity(t *testing.T) {
+	dd := NewLRUDedup("", keyOf, time.Minute, 2)
+	assert.Equal(t, true, dd.Claim(1))
+	assert.Equal(t, true, dd.Claim(2))
+	assert.Equal(t, false, dd.Claim(1))
+	assert.Equal(t, true, dd.Claim(3))
+	// 2 is the least recently used
+	assert.Equal(t, true, dd.Claim(2))
+	assert.Equal(t, false, dd.Claim(3))
+}
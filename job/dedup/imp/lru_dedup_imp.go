@@ -0,0 +1,60 @@
+package imp
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+/////////////////////
+// LRU Dedup IMP //
+type lruEntry struct {
+	key     string
+	expires time.Time
+}
+type lruDedupImp struct {
+	sync.Mutex
+	capacity int
+	order    *list.List
+	entries  map[string]*list.Element
+}
+
+func (ld *lruDedupImp) Name() string { return "lru" }
+func (ld *lruDedupImp) DoClaim(key string, ttl time.Duration) (bool, error) {
+	ld.Lock()
+	defer ld.Unlock()
+	now := time.Now()
+	if e, ok := ld.entries[key]; ok {
+		entry, _ := e.Value.(*lruEntry)
+		if now.Before(entry.expires) {
+			ld.order.MoveToFront(e)
+			return false, nil
+		} else {
+			entry.expires = now.Add(ttl)
+			ld.order.MoveToFront(e)
+			return true, nil
+		}
+	} else {
+		ld.entries[key] = ld.order.PushFront(&lruEntry{key, now.Add(ttl)})
+		for ld.capacity > 0 && ld.order.Len() > ld.capacity {
+			oldest := ld.order.Back()
+			ld.order.Remove(oldest)
+			if entry, ok := oldest.Value.(*lruEntry); ok {
+				delete(ld.entries, entry.key)
+			}
+		}
+		return true, nil
+	}
+}
+func (ld *lruDedupImp) DoRelease(key string) error {
+	ld.Lock()
+	defer ld.Unlock()
+	if e, ok := ld.entries[key]; ok {
+		ld.order.Remove(e)
+		delete(ld.entries, key)
+	}
+	return nil
+}
+func NewLRUDedupImp(capacity int) *lruDedupImp {
+	return &lruDedupImp{sync.Mutex{}, capacity, list.New(), make(map[string]*list.Element)}
+}
@@ -0,0 +1,94 @@
+package imp
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+)
+
+type dialect int
+
+const (
+	Postgres dialect = iota
+	Mysql
+)
+
+// table goes into the statements as is, so only a plain or schema qualified name
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+/////////////////////
+// SQL Dedup IMP //
+// table ( k VARCHAR(255) PRIMARY KEY, expires BIGINT ) gets created on first claim,
+// keys longer than 255 characters get rejected or truncated depending on the database
+type sqlDedupImp struct {
+	db      model.Querier
+	dialect dialect
+	table   string
+	ready   atomic.Value
+}
+
+func (sd *sqlDedupImp) statements() (create, purge, claim, release string) {
+	create = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ( k VARCHAR(255) PRIMARY KEY, expires BIGINT NOT NULL )",
+		sd.table)
+	switch sd.dialect {
+	case Mysql:
+		purge = fmt.Sprintf("DELETE FROM %s WHERE k = ? AND expires <= ?", sd.table)
+		claim = fmt.Sprintf("INSERT IGNORE INTO %s ( k, expires ) VALUES ( ?, ? )", sd.table)
+		release = fmt.Sprintf("DELETE FROM %s WHERE k = ?", sd.table)
+	default:
+		purge = fmt.Sprintf("DELETE FROM %s WHERE k = $1 AND expires <= $2", sd.table)
+		claim = fmt.Sprintf("INSERT INTO %s ( k, expires ) VALUES ( $1, $2 ) ON CONFLICT DO NOTHING", sd.table)
+		release = fmt.Sprintf("DELETE FROM %s WHERE k = $1", sd.table)
+	}
+	return
+}
+
+func (sd *sqlDedupImp) Name() string {
+	switch sd.dialect {
+	case Mysql:
+		return "mysql"
+	default:
+		return "postgres"
+	}
+}
+func (sd *sqlDedupImp) DoClaim(key string, ttl time.Duration) (bool, error) {
+	create, purge, claim, _ := sd.statements()
+	fresh := false
+	err := sd.db.Query(func(db *sql.DB) error {
+		if ready, _ := sd.ready.Load().(bool); !ready {
+			if _, err := db.Exec(create); err != nil {
+				return err
+			}
+			sd.ready.Store(true)
+		}
+		now := time.Now()
+		if _, err := db.Exec(purge, key, now.UnixNano()); err != nil {
+			return err
+		} else if r, err := db.Exec(claim, key, now.Add(ttl).UnixNano()); err != nil {
+			return err
+		} else if affected, err := r.RowsAffected(); err != nil {
+			return err
+		} else {
+			fresh = affected > 0
+			return nil
+		}
+	})
+	return fresh, err
+}
+func (sd *sqlDedupImp) DoRelease(key string) error {
+	_, _, _, release := sd.statements()
+	return sd.db.Query(func(db *sql.DB) error {
+		_, err := db.Exec(release, key)
+		return err
+	})
+}
+func NewSQLDedupImp(db model.Querier, d dialect, table string) (*sqlDedupImp, error) {
+	if !identifier.MatchString(table) {
+		return nil, fmt.Errorf("✗ invalid dedup table ( %s )", table)
+	}
+	return &sqlDedupImp{db: db, dialect: d, table: table}, nil
+}
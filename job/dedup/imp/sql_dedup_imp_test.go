@@ -0,0 +1,167 @@
+package imp
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/stretchr/testify/assert"
+)
+
+////////////////////////////////////////////////////////////////////
+// stubDriver, a table of k -> expires, it tells the statements  //
+// apart by their heads, and reports rows affected as SQL has it //
+type stubDriver struct {
+	sync.Mutex
+	keys  map[string]int64
+	execs []string
+}
+
+var (
+	stubs     = map[string]*stubDriver{}
+	stubsLock sync.Mutex
+)
+
+func init() { sql.Register("dedup-stub", stubRouter{}) }
+
+type stubRouter struct{}
+
+func (stubRouter) Open(name string) (driver.Conn, error) {
+	stubsLock.Lock()
+	defer stubsLock.Unlock()
+	return &stubConn{stubs[name]}, nil
+}
+
+type stubConn struct{ d *stubDriver }
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) { return &stubStmt{c.d, query}, nil }
+func (c *stubConn) Close() error                              { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no tx") }
+
+type stubStmt struct {
+	d     *stubDriver
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return nil, errors.New("no query")
+}
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.Lock()
+	defer s.d.Unlock()
+	s.d.execs = append(s.d.execs, s.query)
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "DELETE") && strings.Contains(s.query, "expires <="):
+		key := args[0].(string)
+		if expires, ok := s.d.keys[key]; ok && expires <= args[1].(int64) {
+			delete(s.d.keys, key)
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "DELETE"):
+		key := args[0].(string)
+		if _, ok := s.d.keys[key]; ok {
+			delete(s.d.keys, key)
+			return driver.RowsAffected(1), nil
+		}
+		return driver.RowsAffected(0), nil
+	case strings.HasPrefix(s.query, "INSERT"):
+		key := args[0].(string)
+		if _, ok := s.d.keys[key]; ok {
+			return driver.RowsAffected(0), nil
+		}
+		s.d.keys[key] = args[1].(int64)
+		return driver.RowsAffected(1), nil
+	default:
+		return nil, errors.New("unknown statement")
+	}
+}
+
+type stubQuerier struct{ db *sql.DB }
+
+func (sq stubQuerier) Query(f func(db *sql.DB) error) error { return f(sq.db) }
+
+func newStub(t *testing.T) (*stubDriver, model.Querier) {
+	d := &stubDriver{keys: map[string]int64{}}
+	stubsLock.Lock()
+	stubs[t.Name()] = d
+	stubsLock.Unlock()
+	db, err := sql.Open("dedup-stub", t.Name())
+	assert.Nil(t, err)
+	return d, stubQuerier{db}
+}
+
+func newSQLDedup(t *testing.T, q model.Querier, d dialect) *sqlDedupImp {
+	sd, err := NewSQLDedupImp(q, d, "public.dedup")
+	assert.Nil(t, err)
+	return sd
+}
+
+func TestSQLDedupClaim(t *testing.T) {
+	for _, d := range []dialect{Postgres, Mysql} {
+		stub, q := newStub(t)
+		sd := newSQLDedup(t, q, d)
+		fresh, err := sd.DoClaim("a", time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, fresh)
+		fresh, err = sd.DoClaim("a", time.Minute)
+		assert.Nil(t, err)
+		assert.False(t, fresh)
+		fresh, err = sd.DoClaim("b", time.Minute)
+		assert.Nil(t, err)
+		assert.True(t, fresh)
+		// table gets created on the first claim only
+		creates := 0
+		for _, e := range stub.execs {
+			if strings.HasPrefix(e, "CREATE TABLE IF NOT EXISTS public.dedup") {
+				creates++
+			}
+		}
+		assert.Equal(t, 1, creates)
+	}
+}
+
+func TestSQLDedupExpiry(t *testing.T) {
+	_, q := newStub(t)
+	sd := newSQLDedup(t, q, Postgres)
+	fresh, _ := sd.DoClaim("a", time.Millisecond*50)
+	assert.True(t, fresh)
+	fresh, _ = sd.DoClaim("a", time.Millisecond*50)
+	assert.False(t, fresh)
+	time.Sleep(time.Millisecond * 100)
+	fresh, err := sd.DoClaim("a", time.Millisecond*50)
+	assert.Nil(t, err)
+	assert.True(t, fresh)
+}
+
+func TestSQLDedupRelease(t *testing.T) {
+	_, q := newStub(t)
+	sd := newSQLDedup(t, q, Mysql)
+	fresh, _ := sd.DoClaim("a", time.Minute)
+	assert.True(t, fresh)
+	assert.Nil(t, sd.DoRelease("a"))
+	fresh, _ = sd.DoClaim("a", time.Minute)
+	assert.True(t, fresh)
+	fresh, _ = sd.DoClaim("a", time.Minute)
+	assert.False(t, fresh)
+}
+
+func TestSQLDedupTable(t *testing.T) {
+	for _, table := range []string{"dedup", "_dedup_1", "public.dedup"} {
+		_, err := NewSQLDedupImp(nil, Postgres, table)
+		assert.Nil(t, err, table)
+	}
+	for _, table := range []string{"", "1dedup", "dedup; DROP TABLE users", "a.b.c", "de-dup", "dedup "} {
+		_, err := NewSQLDedupImp(nil, Postgres, table)
+		assert.NotNil(t, err, table)
+	}
+}
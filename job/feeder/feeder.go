@@ -3,6 +3,7 @@ package feeder
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"sync/atomic"
@@ -14,38 +15,50 @@ import (
 	"github.com/samwooo/bolsa/logging"
 )
 
-/////////////////
-// Feeder IMP //
-type feederImp interface {
+//////////////////////////////////////////////////////
+// Feeder IMP, brokers implement it too, see NewFeeder //
+type Imp interface {
 	Name() string
 	DoPush(chan model.Done, interface{}) error // push sth into a feeder at anytime
 	DoInit(chan model.Done) error              // do sth when init
-	DoWork(chan model.Done) error              // do things
+	DoWork(chan model.Done) error              // do things, io.EOF closes the feeder
 	DoExit(chan model.Done) error              // do sth when exit
 	DoRetry(chan model.Done, model.Done) error // do retry
 }
 
-/////////////////
+///////////////////////////////////////////////////////////////////
+// Optional, for feeders that settle their source once digested, //
+// e.g. ack or nack a message depending on the final outcome    //
+type digester interface {
+	DoDigest(model.Done) error
+}
+
+// Optional, for feeders that never drain on their own, e.g. queues or tailing cursors
+type continuous interface {
+	Continuous() bool
+}
+
+////////////////
 // Job Feeder //
 type Feeder struct {
 	logger  logging.Logger
 	workers int
 	output  chan model.Done
 	closed  atomic.Value
-	feederImp
+	Imp
 }
 
 func (jf *Feeder) Adapt() chan model.Done { return jf.output }
 func (jf *Feeder) Name() string {
-	if jf.feederImp != nil {
-		return jf.feederImp.Name()
+	if jf.Imp != nil {
+		return jf.Imp.Name()
 	} else {
 		return fmt.Sprintf("default")
 	}
 }
 func (jf *Feeder) Retry(d model.Done) {
-	if jf.feederImp != nil && !jf.Closed() {
-		if err := jf.feederImp.DoRetry(jf.output, d); err != nil {
+	if jf.Imp != nil && !jf.Closed() {
+		if err := model.Safely(func() error { return jf.Imp.DoRetry(jf.output, d) }); err != nil {
 			jf.logger.Warnf("✗ feeder %s retry failed ( %s )", jf.Name(), err.Error())
 		} else {
 			jf.logger.Debugf("✔ feeder %s retry ( %+v )", jf.Name(), d)
@@ -55,8 +68,8 @@ func (jf *Feeder) Retry(d model.Done) {
 	}
 }
 func (jf *Feeder) Push(data interface{}) error {
-	if jf.feederImp != nil && !jf.Closed() {
-		if err := jf.feederImp.DoPush(jf.output, data); err != nil {
+	if jf.Imp != nil && !jf.Closed() {
+		if err := model.Safely(func() error { return jf.Imp.DoPush(jf.output, data) }); err != nil {
 			jf.logger.Warnf("✗ feeder %s push failed ( %s )", jf.Name(), err.Error())
 			return err
 		} else {
@@ -69,6 +82,21 @@ func (jf *Feeder) Push(data interface{}) error {
 		return err
 	}
 }
+func (jf *Feeder) Digest(d model.Done) {
+	if dg, ok := jf.Imp.(digester); ok {
+		if err := model.Safely(func() error { return dg.DoDigest(d) }); err != nil {
+			jf.logger.Warnf("✗ feeder %s digest failed ( %s )", jf.Name(), err.Error())
+		} else {
+			jf.logger.Debugf("✔ feeder %s digest ( %+v )", jf.Name(), d)
+		}
+	}
+}
+func (jf *Feeder) Continuous() bool {
+	if c, ok := jf.Imp.(continuous); ok {
+		return c.Continuous()
+	}
+	return false
+}
 func (jf *Feeder) Close() { jf.closed.Store(true) }
 
 func (jf *Feeder) Closed() bool {
@@ -79,7 +107,7 @@ func (jf *Feeder) Closed() bool {
 		return true
 	}
 }
-func newFeeder(ctx context.Context, logger logging.Logger, workers int, RIPRightAfterInit bool, f feederImp) *Feeder {
+func newFeeder(ctx context.Context, logger logging.Logger, workers int, RIPRightAfterInit bool, f Imp) *Feeder {
 	initClosed := func() (closed atomic.Value) {
 		closed.Store(false)
 		return
@@ -101,8 +129,8 @@ func newFeeder(ctx context.Context, logger logging.Logger, workers int, RIPRight
 		})
 
 	go func() {
-		if jf.feederImp != nil && !jf.Closed() {
-			if err := jf.feederImp.DoInit(jf.output); err != nil {
+		if jf.Imp != nil && !jf.Closed() {
+			if err := model.Safely(func() error { return jf.Imp.DoInit(jf.output) }); err != nil {
 				jf.logger.Warnf("✗ feeder %s init failed ( %s )", jf.Name(), err.Error())
 			} else {
 				jf.logger.Debugf("✗ feeder %s init succeed", jf.Name())
@@ -127,16 +155,19 @@ func newFeeder(ctx context.Context, logger logging.Logger, workers int, RIPRight
 		go func() {
 			for {
 				if jf.Closed() {
-					if jf.feederImp != nil {
-						if err := jf.feederImp.DoExit(jf.output); err != nil {
+					if jf.Imp != nil {
+						if err := model.Safely(func() error { return jf.Imp.DoExit(jf.output) }); err != nil {
 							jf.logger.Warnf("✗ feeder %s exit failed ( %s )", jf.Name(), err.Error())
 						}
 					}
 					waitress <- true
 					return
 				} else {
-					if jf.feederImp != nil {
-						if err := jf.feederImp.DoWork(jf.output); err != nil {
+					if jf.Imp != nil {
+						if err := model.Safely(func() error { return jf.Imp.DoWork(jf.output) }); err == io.EOF {
+							jf.logger.Debugf("✔ feeder %s drained", jf.Name())
+							jf.Close()
+						} else if err != nil {
 							jf.logger.Warnf("✗ feeder %s work failed ( %s )", jf.Name(), err.Error())
 						}
 					} else {
@@ -151,6 +182,7 @@ func newFeeder(ctx context.Context, logger logging.Logger, workers int, RIPRight
 	return &jf
 }
 
+// Work keeps getting called until the feeder gets closed, an io.EOF from it won't drain the feeder
 func NewWorkFeeder(ctx context.Context, name string, workers int, init imp.Init, work imp.Work,
 	labor model.Labor, exit imp.Exit) *Feeder {
 	return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, false,
@@ -162,3 +194,43 @@ func NewDataFeeder(ctx context.Context, name string, workers int, data []interfa
 	return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, RIPRightAfterInit,
 		imp.NewDataFeederImp(data, batch))
 }
+
+func NewReaderFeeder(ctx context.Context, name string, workers int, r io.Reader, format imp.Format,
+	batch int) *Feeder {
+	return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, false,
+		imp.NewReaderFeederImp(r, format, batch))
+}
+
+func NewFileFeeder(ctx context.Context, name string, workers int, path string, format imp.Format,
+	batch int) (*Feeder, error) {
+	if f, err := os.Open(path); err != nil {
+		return nil, err
+	} else {
+		return NewReaderFeeder(ctx, name, workers, f, format, batch), nil
+	}
+}
+
+// e.g. database.Postgres or database.Mysql
+func NewSQLFeeder(ctx context.Context, name string, workers int, db model.Querier, paging imp.Paging,
+	row imp.Row, query string, args ...interface{}) (*Feeder, error) {
+	if f, err := imp.NewSQLFeederImp(db, paging, row, query, args...); err != nil {
+		return nil, err
+	} else {
+		return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, false, f), nil
+	}
+}
+
+// e.g. database.Mongo
+func NewMongoFeeder(ctx context.Context, name string, workers int, db imp.Collector, collection string,
+	cursor imp.Cursor) (*Feeder, error) {
+	if f, err := imp.NewMongoFeederImp(ctx, db, collection, cursor); err != nil {
+		return nil, err
+	} else {
+		return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, false, f), nil
+	}
+}
+
+// upon f of a broker or the like, e.g. sqs.NewFeeder
+func NewFeeder(ctx context.Context, name string, workers int, f Imp) *Feeder {
+	return newFeeder(ctx, logging.GetLogger(" "+name+" "), workers, false, f)
+}
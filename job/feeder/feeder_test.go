@@ -0,0 +1,43 @@
+package feeder
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/samwooo/bolsa/job/feeder/imp"
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/samwooo/bolsa/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+type digestFeederImp struct {
+	Imp
+	digested sync.Map
+}
+
+func (df *digestFeederImp) DoDigest(d model.Done) error {
+	if d.E != nil {
+		return d.E
+	} else {
+		df.digested.Store(d.Key, d)
+		return nil
+	}
+}
+
+func TestFeederDigest(t *testing.T) {
+	df := &digestFeederImp{Imp: imp.NewDataFeederImp(nil, 1)}
+	f := newFeeder(context.Background(), logging.GetLogger(""), runtime.NumCPU(), true, df)
+	f.Digest(model.NewDone(nil, 1, nil, 0, 1, "1"))
+	f.Digest(model.NewDone(nil, 2, fmt.Errorf("digest"), 0, 2, "2"))
+	_, ok := df.digested.Load("1")
+	assert.Equal(t, true, ok)
+	_, ok = df.digested.Load("2")
+	assert.Equal(t, false, ok)
+
+	// feeders without DoDigest simply ignore it
+	NewDataFeeder(context.Background(), "", runtime.NumCPU(), nil, 1, true).Digest(
+		model.NewDone(nil, 1, nil, 0, 1, "1"))
+}
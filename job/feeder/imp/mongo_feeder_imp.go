@@ -0,0 +1,206 @@
+package imp
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// satisfied by database.Mongo
+type Collector interface {
+	Query(collection string, f func(c *mgo.Collection) error) error
+}
+
+/////////////////////////////////////////////////////////////////////////////////////
+// Cursor, Tail keeps following a capped collection or the oplog ( local.oplog.rs ) //
+// and resumes from the last seen Key ( _id by default, ts for the oplog )         //
+type Cursor struct {
+	Selector interface{}
+	Sort     []string
+	Batch    int
+	Tail     bool
+	Timeout  time.Duration
+	Key      string
+}
+
+///////////////////////
+// Mongo Feeder IMP //
+type mongoFeederImp struct {
+	ctx        context.Context
+	db         Collector
+	collection string
+	cursor     Cursor
+	docs       chan bson.M
+	stop       chan struct{}
+	err        error
+	start      sync.Once
+	exit       sync.Once
+}
+
+func (mf *mongoFeederImp) query(c *mgo.Collection, last interface{}) *mgo.Query {
+	selector := mf.cursor.Selector
+	if last != nil {
+		selector = bson.M{"$and": []interface{}{selector, bson.M{mf.cursor.Key: bson.M{"$gt": last}}}}
+	}
+	q := c.Find(selector)
+	if len(mf.cursor.Sort) > 0 {
+		q = q.Sort(mf.cursor.Sort...)
+	}
+	if mf.cursor.Batch > 0 {
+		q = q.Batch(mf.cursor.Batch)
+	}
+	return q
+}
+
+// true if the feeder stopped or ctx cancelled while delivering doc
+func (mf *mongoFeederImp) deliver(doc bson.M) bool {
+	select {
+	case mf.docs <- doc:
+		return false
+	case <-mf.stop:
+		return true
+	case <-mf.ctx.Done():
+		return true
+	}
+}
+
+func (mf *mongoFeederImp) stopped() bool {
+	select {
+	case <-mf.stop:
+		return true
+	case <-mf.ctx.Done():
+		return true
+	default:
+		return false
+	}
+}
+
+// true if the feeder stopped or ctx cancelled within d
+func (mf *mongoFeederImp) pause(d time.Duration) bool {
+	select {
+	case <-mf.stop:
+		return true
+	case <-mf.ctx.Done():
+		return true
+	case <-time.After(d):
+		return false
+	}
+}
+
+// satisfied by *mgo.Iter
+type iterator interface {
+	Next(result interface{}) bool
+	Err() error
+	Timeout() bool
+	Close() error
+}
+
+func (mf *mongoFeederImp) iterate(c *mgo.Collection) error {
+	return mf.follow(func(last interface{}) iterator {
+		if mf.cursor.Tail {
+			return mf.query(c, last).Tail(mf.cursor.Timeout)
+		} else {
+			return mf.query(c, last).Iter()
+		}
+	})
+}
+
+// reopens past the last seen key while tailing
+func (mf *mongoFeederImp) follow(open func(last interface{}) iterator) error {
+	var last interface{}
+	for {
+		iter := open(last)
+		doc := bson.M{}
+		for iter.Next(&doc) {
+			last = doc[mf.cursor.Key]
+			if mf.deliver(doc) {
+				return iter.Close()
+			}
+			doc = bson.M{}
+		}
+		if err := iter.Err(); err != nil || !mf.cursor.Tail {
+			iter.Close()
+			return err
+		} else if mf.stopped() {
+			return iter.Close()
+		} else if iter.Timeout() {
+			iter.Close()
+			continue
+		} else {
+			// cursor died, i.e. empty collection, requery after a while
+			iter.Close()
+			if mf.pause(mf.cursor.Timeout) {
+				return nil
+			}
+		}
+	}
+}
+
+func (mf *mongoFeederImp) Name() string     { return "mongo" }
+func (mf *mongoFeederImp) Continuous() bool { return mf.cursor.Tail }
+func (mf *mongoFeederImp) DoInit(ch chan model.Done) error {
+	mf.start.Do(func() {
+		go func() {
+			mf.err = mf.db.Query(mf.collection, mf.iterate)
+			close(mf.docs)
+		}()
+	})
+	return nil
+}
+func (mf *mongoFeederImp) DoWork(ch chan model.Done) error {
+	select {
+	case doc, more := <-mf.docs:
+		if !more {
+			return io.EOF
+		} else {
+			return mf.DoPush(ch, doc)
+		}
+	case <-time.After(time.Millisecond * 50):
+		return nil
+	}
+}
+func (mf *mongoFeederImp) DoExit(ch chan model.Done) (err error) {
+	mf.exit.Do(func() {
+		// never initialised, nothing to wait for
+		mf.start.Do(func() { close(mf.docs) })
+		close(mf.stop)
+		// wait for the cursor to let go of the session
+		for range mf.docs {
+		}
+		err = mf.err
+	})
+	return
+}
+func (mf *mongoFeederImp) DoRetry(ch chan model.Done, d model.Done) error {
+	ch <- d
+	return nil
+}
+func (mf *mongoFeederImp) DoPush(ch chan model.Done, data interface{}) error {
+	ch <- model.NewDone(nil, data, nil, 0, data, model.KeyFrom(data))
+	return nil
+}
+
+// tailable cursors follow the natural order, Sort can't go with Tail
+func NewMongoFeederImp(ctx context.Context, db Collector, collection string,
+	cursor Cursor) (*mongoFeederImp, error) {
+	if cursor.Tail && len(cursor.Sort) > 0 {
+		return nil, fmt.Errorf("✗ tailable cursor on ( %s ) can't be sorted ( %v )", collection, cursor.Sort)
+	}
+	if cursor.Key == "" {
+		cursor.Key = "_id"
+	}
+	if cursor.Timeout <= 0 {
+		cursor.Timeout = time.Second
+	}
+	if cursor.Selector == nil {
+		cursor.Selector = bson.M{}
+	}
+	return &mongoFeederImp{ctx: ctx, db: db, collection: collection, cursor: cursor,
+		docs: make(chan bson.M), stop: make(chan struct{})}, nil
+}
@@ -0,0 +1,122 @@
+package imp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/stretchr/testify/assert"
+	mgo "gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// a cursor over docs, dead or timed out once they're gone
+type fakeIter struct {
+	docs    []bson.M
+	timeout bool
+	err     error
+}
+
+func (fi *fakeIter) Next(result interface{}) bool {
+	if len(fi.docs) == 0 {
+		return false
+	}
+	*result.(*bson.M), fi.docs = fi.docs[0], fi.docs[1:]
+	return true
+}
+func (fi *fakeIter) Err() error    { return fi.err }
+func (fi *fakeIter) Timeout() bool { return fi.timeout }
+func (fi *fakeIter) Close() error  { return fi.err }
+
+type fakeCollector struct{ err error }
+
+func (fc fakeCollector) Query(collection string, f func(c *mgo.Collection) error) error {
+	return fc.err
+}
+
+func newMongoFeeder(t *testing.T, cursor Cursor) *mongoFeederImp {
+	mf, err := NewMongoFeederImp(context.Background(), fakeCollector{}, "C", cursor)
+	assert.Nil(t, err)
+	return mf
+}
+
+func TestMongoFeederRejectsSortedTail(t *testing.T) {
+	_, err := NewMongoFeederImp(context.Background(), fakeCollector{}, "C", Cursor{Tail: true, Sort: []string{"_id"}})
+	assert.NotNil(t, err)
+}
+
+func TestMongoFeederFollowsOnce(t *testing.T) {
+	mf := newMongoFeeder(t, Cursor{})
+	opened := 0
+	done := make(chan error, 1)
+	go func() {
+		done <- mf.follow(func(last interface{}) iterator {
+			opened++
+			return &fakeIter{docs: []bson.M{{"_id": 1}, {"_id": 2}}}
+		})
+	}()
+	assert.Equal(t, bson.M{"_id": 1}, <-mf.docs)
+	assert.Equal(t, bson.M{"_id": 2}, <-mf.docs)
+	assert.Nil(t, <-done)
+	assert.Equal(t, 1, opened)
+}
+
+func TestMongoFeederTailResumes(t *testing.T) {
+	mf := newMongoFeeder(t, Cursor{Tail: true, Timeout: time.Millisecond})
+	var lasts []interface{}
+	done := make(chan error, 1)
+	go func() {
+		done <- mf.follow(func(last interface{}) iterator {
+			lasts = append(lasts, last)
+			switch len(lasts) {
+			case 1:
+				// dies right after, e.g. the capped collection had nothing more
+				return &fakeIter{docs: []bson.M{{"_id": 1}, {"_id": 2}}}
+			case 2:
+				return &fakeIter{docs: []bson.M{{"_id": 3}}, timeout: true}
+			default:
+				return &fakeIter{err: errors.New("oops")}
+			}
+		})
+	}()
+	for i := 1; i <= 3; i++ {
+		assert.Equal(t, bson.M{"_id": i}, <-mf.docs)
+	}
+	assert.NotNil(t, <-done)
+	assert.Equal(t, []interface{}{nil, 2, 3}, lasts)
+}
+
+func TestMongoFeederStopsWhileWaiting(t *testing.T) {
+	mf := newMongoFeeder(t, Cursor{Tail: true, Timeout: time.Minute})
+	opened := make(chan struct{}, 1)
+	done := make(chan error, 1)
+	go func() {
+		done <- mf.follow(func(last interface{}) iterator {
+			opened <- struct{}{}
+			return &fakeIter{}
+		})
+	}()
+	<-opened
+	close(mf.stop)
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("still waiting out the cursor timeout")
+	}
+}
+
+func TestMongoFeederQueryFailed(t *testing.T) {
+	mf, err := NewMongoFeederImp(context.Background(), fakeCollector{errors.New("no reachable servers")}, "C",
+		Cursor{})
+	assert.Nil(t, err)
+	ch := make(chan model.Done, 1)
+	assert.Nil(t, mf.DoInit(ch))
+	for err = mf.DoWork(ch); err == nil; err = mf.DoWork(ch) {
+	}
+	assert.Equal(t, io.EOF, err)
+	assert.EqualError(t, mf.DoExit(ch), "no reachable servers")
+}
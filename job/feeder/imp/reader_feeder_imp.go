@@ -0,0 +1,183 @@
+package imp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/samwooo/bolsa/common"
+	"github.com/samwooo/bolsa/job/model"
+)
+
+type Format int
+
+const (
+	CSV           Format = iota // []string per row
+	CSVWithHeader               // map[string]string per row, keyed by the first row
+	JSONLines                   // interface{} per line
+)
+
+////////////////////////
+// Reader Feeder IMP //
+type readerFeederImp struct {
+	sync.Mutex
+	source io.Reader
+	format Format
+	batch  int
+	lines  *bufio.Reader
+	rows   *csv.Reader
+	header []string
+	shift  sync.Map
+	once   sync.Once
+	failed bool // the source broke, drained once that's reported
+}
+
+// a record that doesn't decode, the ones after it still might
+type decodeError struct{ error }
+
+func recoverable(err error) bool {
+	switch err.(type) {
+	case *csv.ParseError, decodeError:
+		return true
+	default:
+		return false
+	}
+}
+
+func (rf *readerFeederImp) next() (interface{}, error) {
+	switch rf.format {
+	case CSV, CSVWithHeader:
+		if row, err := rf.rows.Read(); err != nil {
+			return nil, err
+		} else if rf.format == CSV {
+			return row, nil
+		} else if rf.header == nil {
+			rf.header = row
+			return rf.next()
+		} else {
+			record := make(map[string]string, len(rf.header))
+			for i, column := range rf.header {
+				if i < len(row) {
+					record[column] = row[i]
+				}
+			}
+			return record, nil
+		}
+	case JSONLines:
+		line, err := rf.lines.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			if err == nil {
+				return rf.next()
+			} else {
+				return nil, err
+			}
+		}
+		var record interface{}
+		if e := json.Unmarshal(line, &record); e != nil {
+			return nil, decodeError{fmt.Errorf("✗ decode error ( %s => %s )", string(line), e.Error())}
+		} else {
+			return record, nil
+		}
+	default:
+		return nil, fmt.Errorf("✗ unknown format ( %d )", rf.format)
+	}
+}
+
+// read up to batch records, stops at the first error along with what's read so far,
+// io.EOF only when nothing left, or once an error of the source itself is reported
+func (rf *readerFeederImp) read() ([]interface{}, error) {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.failed {
+		return nil, io.EOF
+	}
+	size := rf.batch
+	if size < 1 {
+		size = 1
+	}
+	var records []interface{}
+	for len(records) < size {
+		if record, err := rf.next(); err == io.EOF {
+			if len(records) == 0 {
+				return nil, io.EOF
+			}
+			break
+		} else if err != nil {
+			// e.g. a corrupt gzip stream or a closed file fails every read after
+			rf.failed = !recoverable(err)
+			return records, err
+		} else {
+			records = append(records, record)
+		}
+	}
+	return records, nil
+}
+
+func (rf *readerFeederImp) pump(ch chan model.Done) error {
+	var errs []string
+	rf.shift.Range(func(key, value interface{}) bool {
+		if d, ok := value.(model.Done); ok {
+			ch <- d
+		} else {
+			errs = append(errs, fmt.Sprintf("✗ cast error ( %+v => %+v )", key, value))
+		}
+		rf.shift.Delete(key)
+		return true
+	})
+	return common.ErrorFromString(strings.Join(errs, " | "))
+}
+func (rf *readerFeederImp) Name() string                    { return "reader" }
+func (rf *readerFeederImp) DoInit(ch chan model.Done) error { return nil }
+func (rf *readerFeederImp) DoWork(ch chan model.Done) error {
+	if err := rf.pump(ch); err != nil {
+		return err
+	}
+	records, err := rf.read()
+	if err == io.EOF {
+		return err
+	} else if len(records) > 0 {
+		if rf.batch <= 1 {
+			rf.DoPush(ch, records[0])
+		} else {
+			rf.DoPush(ch, records)
+		}
+	}
+	return err
+}
+func (rf *readerFeederImp) DoExit(ch chan model.Done) error {
+	var err error
+	rf.once.Do(func() {
+		rf.Lock()
+		defer rf.Unlock()
+		if closer, ok := rf.source.(io.Closer); ok {
+			err = closer.Close()
+		}
+	})
+	if e := rf.pump(ch); e != nil {
+		return e
+	}
+	return err
+}
+func (rf *readerFeederImp) DoRetry(ch chan model.Done, d model.Done) error {
+	rf.shift.Store(d.String(), d)
+	return nil
+}
+func (rf *readerFeederImp) DoPush(ch chan model.Done, data interface{}) error {
+	ch <- model.NewDone(nil, data, nil, 0, data, model.KeyFrom(data))
+	return nil
+}
+func NewReaderFeederImp(source io.Reader, format Format, batch int) *readerFeederImp {
+	rf := &readerFeederImp{source: source, format: format, batch: batch}
+	if format == JSONLines {
+		rf.lines = bufio.NewReader(source)
+	} else {
+		rf.rows = csv.NewReader(source)
+		rf.rows.FieldsPerRecord = -1
+	}
+	return rf
+}
@@ -0,0 +1,267 @@
+package imp
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/samwooo/bolsa/common"
+	"github.com/samwooo/bolsa/job/model"
+)
+
+//////////////////////////////////////////////////////////////////////////////////
+// Paging, empty Key means OFFSET paging otherwise keyset paging on column Key, //
+// query must then take the last seen key as its last argument, e.g. id > $1, //
+// starting after From, key > NULL matches nothing so From is a must then,    //
+// either way query must ORDER BY the key, or pages skip & repeat rows        //
+type Paging struct {
+	Size     int
+	Key      string
+	From     interface{}
+	Failures int // pages failed in a row before the feeder gives up, 8 by default
+}
+
+/////////////////////////////////////////////////////////////////
+// Row scans the current row, nil gives map[string]interface{} //
+type Row func(rows *sql.Rows) (interface{}, error)
+
+// scan rows into new instances of sample's struct type by db tag or field name
+func StructRow(sample interface{}) Row {
+	t := reflect.TypeOf(sample)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return func(rows *sql.Rows) (interface{}, error) {
+		columns, err := rows.Columns()
+		if err != nil {
+			return nil, err
+		}
+		v := reflect.New(t)
+		targets := make([]interface{}, len(columns))
+		for i, column := range columns {
+			if field, ok := fieldOf(v.Elem(), column); ok {
+				targets[i] = field.Addr().Interface()
+			} else {
+				targets[i] = new(interface{})
+			}
+		}
+		if err := rows.Scan(targets...); err != nil {
+			return nil, err
+		} else {
+			return v.Interface(), nil
+		}
+	}
+}
+
+func fieldOf(v reflect.Value, column string) (reflect.Value, bool) {
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		if tag := strings.Split(f.Tag.Get("db"), ",")[0]; tag == column ||
+			(tag == "" && strings.EqualFold(f.Name, column)) {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func mapRow(rows *sql.Rows) (interface{}, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, len(columns))
+	targets := make([]interface{}, len(columns))
+	for i := range values {
+		targets[i] = &values[i]
+	}
+	if err := rows.Scan(targets...); err != nil {
+		return nil, err
+	}
+	record := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if b, ok := values[i].([]byte); ok {
+			record[column] = string(b)
+		} else {
+			record[column] = values[i]
+		}
+	}
+	return record, nil
+}
+
+// ahead of the next try after failures in a row, 100ms doubling up to 5s
+func backoff(failures int) time.Duration {
+	if failures > 7 {
+		failures = 7
+	}
+	if d := 50 * time.Millisecond << uint(failures); d < 5*time.Second {
+		return d
+	}
+	return 5 * time.Second
+}
+
+////////////////////
+// SQL Feeder IMP //
+type sqlFeederImp struct {
+	sync.Mutex
+	db       model.Querier
+	query    string
+	args     []interface{}
+	paging   Paging
+	row      Row
+	offset   int
+	cursor   interface{}
+	drained  bool
+	failures int // pages failed in a row
+	shift    sync.Map
+}
+
+func (sf *sqlFeederImp) keyOf(record interface{}) interface{} {
+	if m, ok := record.(map[string]interface{}); ok {
+		return m[sf.paging.Key]
+	}
+	v := reflect.Indirect(reflect.ValueOf(record))
+	if v.Kind() == reflect.Struct {
+		if field, ok := fieldOf(v, sf.paging.Key); ok {
+			return field.Interface()
+		}
+	}
+	return nil
+}
+
+// fetch the next page, io.EOF once the last one is gone, a page without its key is the last one
+func (sf *sqlFeederImp) page() ([]interface{}, error) {
+	sf.Lock()
+	defer sf.Unlock()
+	if sf.drained {
+		return nil, io.EOF
+	}
+	query := fmt.Sprintf("%s LIMIT %d", sf.query, sf.paging.Size)
+	args := sf.args
+	if sf.paging.Key == "" {
+		query = fmt.Sprintf("%s OFFSET %d", query, sf.offset)
+	} else {
+		args = append(append([]interface{}{}, sf.args...), sf.cursor)
+	}
+	var records []interface{}
+	if err := sf.db.Query(func(db *sql.DB) error {
+		rows, err := db.Query(query, args...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if record, err := sf.row(rows); err != nil {
+				return err
+			} else {
+				records = append(records, record)
+			}
+		}
+		return rows.Err()
+	}); err != nil {
+		return nil, err
+	}
+	if len(records) > 0 && sf.paging.Key != "" {
+		if cursor := sf.keyOf(records[len(records)-1]); cursor == nil {
+			// key > NULL matches nothing, no way to page on
+			sf.drained = true
+			return records, fmt.Errorf("✗ paging key ( %s ) missing in ( %+v )", sf.paging.Key,
+				records[len(records)-1])
+		} else {
+			sf.cursor = cursor
+		}
+	}
+	sf.offset += len(records)
+	sf.drained = len(records) < sf.paging.Size
+	return records, nil
+}
+
+func (sf *sqlFeederImp) pump(ch chan model.Done) error {
+	var errs []string
+	sf.shift.Range(func(key, value interface{}) bool {
+		if d, ok := value.(model.Done); ok {
+			ch <- d
+		} else {
+			errs = append(errs, fmt.Sprintf("✗ cast error ( %+v => %+v )", key, value))
+		}
+		sf.shift.Delete(key)
+		return true
+	})
+	return common.ErrorFromString(strings.Join(errs, " | "))
+}
+func (sf *sqlFeederImp) Name() string                    { return "sql" }
+func (sf *sqlFeederImp) DoInit(ch chan model.Done) error { return nil }
+func (sf *sqlFeederImp) DoWork(ch chan model.Done) error {
+	if err := sf.pump(ch); err != nil {
+		return err
+	}
+	records, err := sf.page()
+	if err == io.EOF {
+		return err
+	} else if len(records) > 0 {
+		sf.DoPush(ch, records)
+	}
+	if err != nil {
+		return sf.failed(err)
+	}
+	sf.Lock()
+	sf.failures = 0
+	sf.Unlock()
+	return nil
+}
+
+// backs off ahead of the next page, the feeder drains once Failures pages failed in a row
+func (sf *sqlFeederImp) failed(err error) error {
+	sf.Lock()
+	sf.failures++
+	failures, drained := sf.failures, sf.drained
+	sf.drained = drained || failures >= sf.paging.Failures
+	sf.Unlock()
+	if drained {
+		return err
+	} else if failures >= sf.paging.Failures {
+		return fmt.Errorf("✗ give up after ( %d ) pages failed ( %s )", failures, err.Error())
+	}
+	time.Sleep(backoff(failures))
+	return err
+}
+func (sf *sqlFeederImp) DoExit(ch chan model.Done) error { return sf.pump(ch) }
+func (sf *sqlFeederImp) DoRetry(ch chan model.Done, d model.Done) error {
+	sf.shift.Store(d.String(), d)
+	return nil
+}
+func (sf *sqlFeederImp) DoPush(ch chan model.Done, data interface{}) error {
+	store := func(d interface{}) {
+		ch <- model.NewDone(nil, d, nil, 0, d, model.KeyFrom(d))
+	}
+	if dataArray, ok := data.([]interface{}); ok {
+		for _, d := range dataArray {
+			store(d)
+		}
+	} else {
+		store(data)
+	}
+	return nil
+}
+func NewSQLFeederImp(db model.Querier, paging Paging, row Row, query string,
+	args ...interface{}) (*sqlFeederImp, error) {
+	if paging.Key != "" && paging.From == nil {
+		return nil, fmt.Errorf("✗ keyset paging on ( %s ) without From", paging.Key)
+	}
+	if paging.Size <= 0 {
+		paging.Size = 100
+	}
+	if paging.Failures <= 0 {
+		paging.Failures = 8
+	}
+	if row == nil {
+		row = mapRow
+	}
+	return &sqlFeederImp{db: db, query: query, args: args, paging: paging, row: row, cursor: paging.From}, nil
+}
@@ -0,0 +1,242 @@
+package imp
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/stretchr/testify/assert"
+)
+
+/////////////////////////////////////////////////////////////////////
+// stubDriver, a table of ( id, name ) ordered by id, it honours   //
+// LIMIT & OFFSET, and a last argument as the key to page after, //
+// NULL compared to a key is never true as SQL has it            //
+type stubDriver struct {
+	sync.Mutex
+	rows    [][]driver.Value
+	queries []string
+	fail    int
+}
+
+var (
+	stubs     = map[string]*stubDriver{}
+	stubsLock sync.Mutex
+	limitOf   = regexp.MustCompile(`LIMIT (\d+)`)
+	offsetOf  = regexp.MustCompile(`OFFSET (\d+)`)
+)
+
+func init() { sql.Register("stub", stubRouter{}) }
+
+type stubRouter struct{}
+
+func (stubRouter) Open(name string) (driver.Conn, error) {
+	stubsLock.Lock()
+	defer stubsLock.Unlock()
+	return &stubConn{stubs[name]}, nil
+}
+
+type stubConn struct{ d *stubDriver }
+
+func (c *stubConn) Prepare(query string) (driver.Stmt, error) { return &stubStmt{c.d, query}, nil }
+func (c *stubConn) Close() error                              { return nil }
+func (c *stubConn) Begin() (driver.Tx, error)                 { return nil, errors.New("no tx") }
+
+type stubStmt struct {
+	d     *stubDriver
+	query string
+}
+
+func (s *stubStmt) Close() error  { return nil }
+func (s *stubStmt) NumInput() int { return -1 }
+func (s *stubStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("no exec")
+}
+func (s *stubStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.Lock()
+	defer s.d.Unlock()
+	s.d.queries = append(s.d.queries, s.query)
+	if s.d.fail > 0 {
+		s.d.fail--
+		return nil, errors.New("connection refused")
+	}
+	limit, offset := len(s.d.rows), 0
+	if m := limitOf.FindStringSubmatch(s.query); m != nil {
+		limit, _ = strconv.Atoi(m[1])
+	}
+	if m := offsetOf.FindStringSubmatch(s.query); m != nil {
+		offset, _ = strconv.Atoi(m[1])
+	}
+	var rows [][]driver.Value
+	for _, row := range s.d.rows {
+		if len(args) > 0 {
+			if after, ok := args[len(args)-1].(int64); !ok || row[0].(int64) <= after {
+				continue
+			}
+		}
+		rows = append(rows, row)
+	}
+	if offset > len(rows) {
+		offset = len(rows)
+	}
+	rows = rows[offset:]
+	if limit < len(rows) {
+		rows = rows[:limit]
+	}
+	return &stubRows{rows: rows}, nil
+}
+
+type stubRows struct{ rows [][]driver.Value }
+
+func (r *stubRows) Columns() []string { return []string{"id", "name"} }
+func (r *stubRows) Close() error      { return nil }
+func (r *stubRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+type stubQuerier struct{ db *sql.DB }
+
+func (sq stubQuerier) Query(f func(db *sql.DB) error) error { return f(sq.db) }
+
+func newStub(t *testing.T, rows int) (*stubDriver, model.Querier) {
+	d := &stubDriver{}
+	for i := 1; i <= rows; i++ {
+		d.rows = append(d.rows, []driver.Value{int64(i), []byte("name-" + strconv.Itoa(i))})
+	}
+	stubsLock.Lock()
+	stubs[t.Name()] = d
+	stubsLock.Unlock()
+	db, err := sql.Open("stub", t.Name())
+	assert.Nil(t, err)
+	return d, stubQuerier{db}
+}
+
+func newSQLFeeder(t *testing.T, q model.Querier, paging Paging, row Row, query string) *sqlFeederImp {
+	sf, err := NewSQLFeederImp(q, paging, row, query)
+	assert.Nil(t, err)
+	return sf
+}
+
+// DoWork until drained, or failures in a row
+func feed(sf *sqlFeederImp, failures int) (records []interface{}, errs []error) {
+	ch := make(chan model.Done, 100)
+	for {
+		if err := sf.DoWork(ch); err == io.EOF {
+			break
+		} else if err != nil {
+			if errs = append(errs, err); len(errs) >= failures {
+				break
+			}
+		}
+	}
+	close(ch)
+	for d := range ch {
+		records = append(records, d.D)
+	}
+	return
+}
+
+type person struct {
+	Id   int64
+	Name string `db:"name"`
+	note string
+}
+
+func TestSQLFeederOffsetPaging(t *testing.T) {
+	d, q := newStub(t, 7)
+	records, errs := feed(newSQLFeeder(t, q, Paging{Size: 3}, nil, "SELECT id, name FROM t ORDER BY id"), 1)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, 7, len(records))
+	assert.Equal(t, map[string]interface{}{"id": int64(1), "name": "name-1"}, records[0])
+	assert.Equal(t, []string{
+		"SELECT id, name FROM t ORDER BY id LIMIT 3 OFFSET 0",
+		"SELECT id, name FROM t ORDER BY id LIMIT 3 OFFSET 3",
+		"SELECT id, name FROM t ORDER BY id LIMIT 3 OFFSET 6"}, d.queries)
+}
+
+func TestSQLFeederKeysetPaging(t *testing.T) {
+	d, q := newStub(t, 6)
+	records, errs := feed(newSQLFeeder(t, q, Paging{Size: 2, Key: "id", From: int64(1)}, StructRow(person{}),
+		"SELECT id, name FROM t WHERE id > $1 ORDER BY id"), 1)
+	assert.Equal(t, 0, len(errs))
+	assert.Equal(t, 5, len(records))
+	assert.Equal(t, &person{Id: 2, Name: "name-2"}, records[0])
+	assert.Equal(t, &person{Id: 6, Name: "name-6"}, records[4])
+	// the last page comes back short of the size, nothing left then
+	assert.Equal(t, 3, len(d.queries))
+}
+
+func TestSQLFeederKeysetFromNull(t *testing.T) {
+	_, q := newStub(t, 6)
+	_, err := NewSQLFeederImp(q, Paging{Size: 2, Key: "id"}, nil, "SELECT id, name FROM t WHERE id > $1 ORDER BY id")
+	assert.NotNil(t, err)
+	// as the database would have it
+	assert.Nil(t, q.Query(func(db *sql.DB) error {
+		rows, err := db.Query("SELECT id, name FROM t WHERE id > $1 ORDER BY id LIMIT 2", nil)
+		assert.Nil(t, err)
+		defer rows.Close()
+		assert.False(t, rows.Next())
+		return nil
+	}))
+}
+
+func TestSQLFeederMissingKey(t *testing.T) {
+	d, q := newStub(t, 6)
+	records, errs := feed(newSQLFeeder(t, q, Paging{Size: 2, Key: "uid", From: int64(0)}, nil,
+		"SELECT id, name FROM t WHERE id > $1 ORDER BY id"), 2)
+	// the first page made it, nothing to page on after it
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, 1, len(errs))
+	assert.Contains(t, errs[0].Error(), "paging key ( uid ) missing")
+	assert.Equal(t, 1, len(d.queries))
+}
+
+func TestSQLFeederBacksOff(t *testing.T) {
+	d, q := newStub(t, 2)
+	d.fail = 2
+	start := time.Now()
+	records, errs := feed(newSQLFeeder(t, q, Paging{Size: 5}, nil, "SELECT id, name FROM t ORDER BY id"), 3)
+	assert.Equal(t, 2, len(records))
+	assert.Equal(t, 2, len(errs))
+	assert.True(t, time.Since(start) >= backoff(1)+backoff(2))
+}
+
+func TestSQLFeederGivesUp(t *testing.T) {
+	d, q := newStub(t, 2)
+	d.fail = 100
+	records, errs := feed(newSQLFeeder(t, q, Paging{Size: 5, Failures: 3}, nil,
+		"SELECT id, name FROM t ORDER BY id"), 100)
+	assert.Equal(t, 0, len(records))
+	assert.Equal(t, 3, len(errs))
+	assert.Contains(t, errs[2].Error(), "give up after ( 3 ) pages failed")
+	assert.Equal(t, 3, len(d.queries))
+}
+
+func TestSQLFeederKeyOf(t *testing.T) {
+	sf := newSQLFeeder(t, nil, Paging{Key: "name", From: ""}, nil, "")
+	assert.Equal(t, "Lucy", sf.keyOf(map[string]interface{}{"name": "Lucy"}))
+	assert.Equal(t, "Lucy", sf.keyOf(&person{Name: "Lucy"}))
+	assert.Equal(t, "Lucy", sf.keyOf(person{Name: "Lucy"}))
+	assert.Nil(t, sf.keyOf(map[string]interface{}{"id": 1}))
+	sf.paging.Key = "note"
+	assert.Nil(t, sf.keyOf(person{note: "unexported"}))
+	assert.Nil(t, sf.keyOf([]string{"Lucy"}))
+}
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, backoff(1))
+	assert.Equal(t, 200*time.Millisecond, backoff(2))
+	assert.Equal(t, 5*time.Second, backoff(100))
+}
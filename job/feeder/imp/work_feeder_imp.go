@@ -1,12 +1,16 @@
 package imp
 
 import (
+	"fmt"
+	"io"
+
 	"github.com/samwooo/bolsa/job/model"
 )
 
 ////////////////////////////////////////////////////////////////////////////////////
 // 1: If only one single data would appear in Work, then simply call Labor(date) //
 // 2: If have to iterate multiple data then call Labor(data) on each single one //
+// 3: An io.EOF from Work is just another error, it never drains the feeder     //
 type Work func(model.Labor) error
 type Init func(chan model.Done) error
 type Exit func(chan model.Done) error
@@ -53,7 +57,12 @@ func (wf *workFeederImp) DoWork(ch chan model.Done) error {
 		}
 	}
 	if wf.work != nil {
-		return wf.work(labor())
+		// io.EOF closes a feeder, keep Work running until the feeder gets closed
+		if err := wf.work(labor()); err == io.EOF {
+			return fmt.Errorf("work ( %s )", err.Error())
+		} else {
+			return err
+		}
 	} else {
 		return nil
 	}
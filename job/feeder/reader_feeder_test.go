@@ -0,0 +1,118 @@
+package feeder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/samwooo/bolsa/job/feeder/imp"
+	"github.com/stretchr/testify/assert"
+)
+
+const csvData = "id,name\n1,Lucy\n2,Lily\n3,Sam\n4,Woo\n5,Bolsa\n"
+const jsonLinesData = `{"id":1,"name":"Lucy"}
+{"id":2,"name":"Lily"}
+
+{"id":3,"name":"Sam"}
+{"id":4,"name":"Woo"}
+{"id":5,"name":"Bolsa"}`
+
+func collect(f *Feeder) (rs []interface{}) {
+	for d := range f.Adapt() {
+		rs = append(rs, d.R)
+	}
+	return
+}
+
+func TestReaderFeederWithCSV(t *testing.T) {
+	f := NewReaderFeeder(context.Background(), "", runtime.NumCPU(), strings.NewReader(csvData), imp.CSV, 1)
+	rs := collect(f)
+	assert.Equal(t, 6, len(rs))
+	for _, r := range rs {
+		row, ok := r.([]string)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, 2, len(row))
+	}
+}
+
+func TestReaderFeederWithCSVHeader(t *testing.T) {
+	f := NewReaderFeeder(context.Background(), "", runtime.NumCPU(), strings.NewReader(csvData),
+		imp.CSVWithHeader, 1)
+	rs := collect(f)
+	assert.Equal(t, 5, len(rs))
+	for _, r := range rs {
+		row, ok := r.(map[string]string)
+		assert.Equal(t, true, ok)
+		assert.NotEmpty(t, row["id"])
+		assert.NotEmpty(t, row["name"])
+	}
+}
+
+func TestReaderFeederWithJSONLines(t *testing.T) {
+	f := NewReaderFeeder(context.Background(), "", runtime.NumCPU(), strings.NewReader(jsonLinesData),
+		imp.JSONLines, 1)
+	rs := collect(f)
+	assert.Equal(t, 5, len(rs))
+	for _, r := range rs {
+		row, ok := r.(map[string]interface{})
+		assert.Equal(t, true, ok)
+		assert.NotNil(t, row["id"])
+	}
+}
+
+func TestReaderFeederWithBatch(t *testing.T) {
+	f := NewReaderFeeder(context.Background(), "", runtime.NumCPU(), strings.NewReader(jsonLinesData),
+		imp.JSONLines, 2)
+	rs := collect(f)
+	assert.Equal(t, 3, len(rs))
+	count := 0
+	for _, r := range rs {
+		batch, ok := r.([]interface{})
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, len(batch) <= 2)
+		count += len(batch)
+	}
+	assert.Equal(t, 5, count)
+}
+
+func TestFileFeeder(t *testing.T) {
+	file, err := ioutil.TempFile("", "bolsa")
+	assert.Nil(t, err)
+	defer os.Remove(file.Name())
+	file.WriteString(csvData)
+	file.Close()
+
+	f, err := NewFileFeeder(context.Background(), "", runtime.NumCPU(), file.Name(), imp.CSVWithHeader, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(collect(f)))
+
+	_, err = NewFileFeeder(context.Background(), "", runtime.NumCPU(), file.Name()+"?", imp.CSV, 0)
+	assert.NotNil(t, err)
+}
+
+type brokenReader struct{ reads int32 }
+
+func (br *brokenReader) Read([]byte) (int, error) {
+	atomic.AddInt32(&br.reads, 1)
+	return 0, errors.New("broken pipe")
+}
+
+func TestReaderFeederWithBrokenSource(t *testing.T) {
+	for _, format := range []imp.Format{imp.CSV, imp.JSONLines} {
+		br := &brokenReader{}
+		f := NewReaderFeeder(context.Background(), "", 1, br, format, 2)
+		assert.Equal(t, 0, len(collect(f)))
+		assert.Equal(t, int32(1), atomic.LoadInt32(&br.reads))
+	}
+}
+
+func TestReaderFeederSkipsUndecodable(t *testing.T) {
+	f := NewReaderFeeder(context.Background(), "", 1, strings.NewReader("{\"id\":1}\n{oops\n{\"id\":2}\n"),
+		imp.JSONLines, 1)
+	assert.Equal(t, 2, len(collect(f)))
+}
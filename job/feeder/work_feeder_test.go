@@ -3,6 +3,7 @@ package feeder
 import (
 	"context"
 	"fmt"
+	"io"
 	"runtime"
 	"sync/atomic"
 	"testing"
@@ -174,3 +175,16 @@ func TestWorkFeederWithinMultipleGoroutines(t *testing.T) {
 	testWithMultipleGoroutinesWithoutError(t, true)
 	testWithMultipleGoroutinesWithoutError(t, false)
 }
+
+func TestWorkFeederIgnoresEOF(t *testing.T) {
+	var calls uint64
+	f := NewWorkFeeder(context.Background(), "", 1, nil, func(labor model.Labor) error {
+		atomic.AddUint64(&calls, 1)
+		return io.EOF
+	}, nil, nil)
+	time.Sleep(time.Millisecond * 50)
+	assert.Equal(t, true, atomic.LoadUint64(&calls) > 1)
+	f.Close()
+	for range f.Adapt() {
+	}
+}
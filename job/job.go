@@ -5,6 +5,7 @@ import (
 	"runtime"
 	"sync"
 
+	"github.com/samwooo/bolsa/job/dedup"
 	"github.com/samwooo/bolsa/job/feeder"
 	"github.com/samwooo/bolsa/job/model"
 	"github.com/samwooo/bolsa/job/task"
@@ -17,9 +18,11 @@ type Job struct {
 	Logger  logging.Logger
 	name    string
 	workers int
+	dedup   *dedup.Dedup
 	*feeder.Feeder
 	model.LaborStrategy
 	model.RetryStrategy
+	keep bool // results kept for Run to return, not for continuous feeders by default
 }
 
 func (j *Job) worthRetry(d model.Done) bool {
@@ -38,6 +41,22 @@ func (j *Job) retryLimit() int {
 	}
 }
 
+// retries of the same data are never duplicates
+func (j *Job) claim(d model.Done) bool {
+	if j.dedup == nil || d.Retries > 0 {
+		return true
+	} else {
+		return j.dedup.Claim(d.D)
+	}
+}
+
+// give up the key on failure so a redelivery can try again
+func (j *Job) release(d model.Done) {
+	if j.dedup != nil {
+		j.dedup.Release(d.D)
+	}
+}
+
 func (j *Job) drain(input <-chan model.Done) <-chan model.Done {
 	return task.NewTask(j.Logger, fmt.Sprintf("%s-drain", j.name),
 		func(d model.Done) (model.Done, bool) {
@@ -49,12 +68,29 @@ func (j *Job) drain(input <-chan model.Done) <-chan model.Done {
 
 func (j *Job) chew(input <-chan model.Done) <-chan model.Done {
 	type worker func(p interface{}) (r interface{}, e error)
+	safely := func(work worker) worker {
+		return func(p interface{}) (r interface{}, e error) {
+			e = model.Safely(func() error {
+				r, e = work(p)
+				return e
+			})
+			return
+		}
+	}
 	chewWithLabor := func(workers int, input <-chan model.Done, work worker) <-chan model.Done {
 		return task.NewTask(j.Logger, fmt.Sprintf("%s-chew", j.name),
 			func(d model.Done) (model.Done, bool) {
+				if !j.claim(d) {
+					j.Logger.Infof("✔ job %s chew skipped duplicate ( %+v )", j.name, d)
+					dedupError := model.NewError(model.TypeDedup, fmt.Errorf("( %+v, duplicated )", d.P))
+					return model.NewDone(d.P, nil, dedupError, d.Retries, d.D, d.Key), true
+				}
 				if data, err := work(d.P); err != nil {
 					j.Logger.Warnf("✗ job %s chew failed ( %+v, %s )", j.name, d, err.Error())
 					laborError := model.NewError(model.TypeLabor, fmt.Errorf("( %+v, %s )", d.P, err.Error()))
+					if panicError, ok := err.(*model.Error); ok && panicError.T == model.TypePanic {
+						laborError = panicError
+					}
 					// P is P & R is R for digest
 					laborFailed := model.NewDone(d.P, data, laborError, d.Retries, d.D, d.Key)
 					if j.worthRetry(laborFailed) && d.Retries < j.retryLimit() {
@@ -62,8 +98,12 @@ func (j *Job) chew(input <-chan model.Done) <-chan model.Done {
 						lr := model.NewDone(nil, d.P, laborError, d.Retries+1, d.D, d.Key)
 						j.Logger.Warnf("✔ job %s RetryStrategy chew failure ( %+v )", j.name, lr)
 						j.Feeder.Retry(lr)
+						if j.Feeder.Closed() {
+							j.release(d)
+						}
 						return laborFailed, laborFailed.Retries > j.retryLimit() || j.Feeder.Closed()
 					} else {
+						j.release(d)
 						return laborFailed, true
 					}
 				} else {
@@ -74,7 +114,7 @@ func (j *Job) chew(input <-chan model.Done) <-chan model.Done {
 			}).Run(workers, input)
 	}
 	if j.LaborStrategy != nil {
-		return chewWithLabor(j.workers, input, j.LaborStrategy.Work)
+		return chewWithLabor(j.workers, input, safely(j.LaborStrategy.Work))
 	} else {
 		return chewWithLabor(j.workers, input,
 			func(para interface{}) (interface{}, error) {
@@ -111,7 +151,9 @@ func (j *Job) description() string {
 			"      ⬨ Feeder          %s\n"+
 			"      ⬨ Workers         %d\n"+
 			"      ⬨ LaborStrategy   %s\n"+
-			"      ⬨ RetryStrategy   %s\n",
+			"      ⬨ RetryStrategy   %s\n"+
+			"      ⬨ Dedup           %s\n"+
+			"      ⬨ Results         %s\n",
 		j.name,
 		j.Feeder.Name(),
 		j.workers,
@@ -130,11 +172,33 @@ func (j *Job) description() string {
 				return "✗"
 			}
 		}(),
+		func() string {
+			if j.dedup != nil {
+				return fmt.Sprintf("✔ ( %s, %+v )", j.dedup.Name(), j.dedup.TTL())
+			} else {
+				return "✗"
+			}
+		}(),
+		func() string {
+			if j.keep {
+				return "✔"
+			} else {
+				return "✗"
+			}
+		}(),
 	)
 }
 
 func (j *Job) SetFeeder(f *feeder.Feeder) *Job {
 	j.Feeder = f
+	j.keep = !f.Continuous()
+	return j
+}
+
+// whether Run keeps every result until the feeder closes, a continuous feeder never does, so by default
+// its results are only digested, keeping them grows memory without bound
+func (j *Job) SetKeepResults(keep bool) *Job {
+	j.keep = keep
 	return j
 }
 
@@ -148,6 +212,12 @@ func (j *Job) SetRetryStrategy(rh model.RetryStrategy) *Job {
 	return j
 }
 
+func (j *Job) SetDedup(d *dedup.Dedup) *Job {
+	j.dedup = d
+	return j
+}
+
+// blocks until the feeder closes, empty unless results are kept, see SetKeepResults
 func (j *Job) Run() *sync.Map {
 	j.Logger.Info(j.description())
 	if j.Feeder == nil {
@@ -157,7 +227,10 @@ func (j *Job) Run() *sync.Map {
 		go func() {
 			var result sync.Map
 			for r := range j.digest(j.chew(j.drain(j.Feeder.Adapt()))) {
-				if v, existing := result.Load(r.Key); existing {
+				j.Feeder.Digest(r)
+				if !j.keep {
+					continue
+				} else if v, existing := result.Load(r.Key); existing {
 					if d, _ := v.(model.Done); d.Retries < r.Retries {
 						result.Store(r.Key, r)
 					}
@@ -179,7 +252,7 @@ func NewJob(name string, workers int, feeder *feeder.Feeder) *Job {
 	if feeder == nil {
 		panic("unable to initialise a job without a feeder!")
 	} else {
-		return &Job{logging.GetLogger(" " + name + " "), name, workers, feeder,
-			nil, nil}
+		return &Job{logging.GetLogger(" " + name + " "), name, workers, nil, feeder,
+			nil, nil, !feeder.Continuous()}
 	}
 }
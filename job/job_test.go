@@ -9,7 +9,9 @@ import (
 	"time"
 
 	"github.com/samwooo/bolsa/common"
+	"github.com/samwooo/bolsa/job/dedup"
 	"github.com/samwooo/bolsa/job/feeder"
+	"github.com/samwooo/bolsa/job/feeder/imp"
 	"github.com/samwooo/bolsa/job/model"
 	"github.com/samwooo/bolsa/logging"
 	"github.com/stretchr/testify/assert"
@@ -478,3 +480,87 @@ func TestJobWithPushArrayBatchBiggerThan1(t *testing.T) {
 func TestJobWithPushArrayBatchBiggerThanSize(t *testing.T) {
 	testJobWithAdditionalPush(t, 100, []interface{}{100, 101, 121})
 }
+
+func TestJobWithDedup(t *testing.T) {
+	with := []interface{}{1, 2, 3, 1, 2, 3}
+	jt := &JobTester{NewJob("", runtime.NumCPU(),
+		feeder.NewDataFeeder(context.Background(), "", runtime.NumCPU(), with,
+			1, false)), 3}
+	jt.SetLaborStrategy(&laborWithoutError{}).SetRetryStrategy(jt).SetDedup(
+		dedup.NewLRUDedup("", func(data interface{}) (string, bool) {
+			return fmt.Sprintf("%+v", data), true
+		}, time.Minute, 100))
+	time.AfterFunc(time.Millisecond*500, func() { jt.Close() })
+	r := jt.Run()
+	count, duplicates := 0, 0
+	r.Range(func(key, value interface{}) bool {
+		done, ok := value.(model.Done)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, common.IsIn(done.D, with))
+		if done.E != nil {
+			assert.Equal(t, true, model.IsDuplicate(done.E))
+			assert.Equal(t, nil, done.R)
+			duplicates++
+		} else {
+			assert.Equal(t, true, common.IsIn(done.R, with))
+		}
+		count++
+		return true
+	})
+	assert.Equal(t, len(with), count)
+	assert.Equal(t, 3, duplicates)
+}
+
+type laborWithPanic struct{}
+
+func (a *laborWithPanic) Work(p interface{}) (r interface{}, e error) {
+	panic("test laborWithPanic")
+}
+
+func TestJobWithLaborPanicButRetry(t *testing.T) {
+	with := []interface{}{1, 2, 3}
+	jt := newJobTester(&laborWithPanic{}, with, 1, 3, false)
+	r := jt.Run()
+	count := 0
+	r.Range(func(key, value interface{}) bool {
+		done, ok := value.(model.Done)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, true, common.IsIn(done.D, with))
+		assert.Equal(t, true, common.IsIn(done.P, with))
+		assert.Equal(t, nil, done.R)
+		assert.Equal(t, "✗ panic failed ( test laborWithPanic )", done.E.Error())
+		jobError, ok := done.E.(*model.Error)
+		assert.Equal(t, true, ok)
+		assert.Equal(t, model.TypePanic, jobError.T)
+		assert.Contains(t, jobError.Stack, "laborWithPanic")
+		count++
+		return true
+	})
+	assert.Equal(t, len(with), count)
+}
+
+type continuousFeederImp struct{ feeder.Imp }
+
+func (continuousFeederImp) Continuous() bool { return true }
+
+func TestJobKeepResults(t *testing.T) {
+	with := []interface{}{1, 2, 3}
+	j := NewJob("", runtime.NumCPU(), feeder.NewDataFeeder(context.Background(), "", runtime.NumCPU(), with,
+		1, false))
+	j.SetKeepResults(false)
+	time.AfterFunc(time.Millisecond*200, func() { j.Close() })
+	count := 0
+	j.Run().Range(func(key, value interface{}) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 0, count)
+
+	// continuous feeders don't keep results unless told to
+	f := feeder.NewFeeder(context.Background(), "", 1,
+		continuousFeederImp{imp.NewDataFeederImp(with, 1)})
+	assert.Equal(t, true, f.Continuous())
+	assert.Equal(t, false, NewJob("", 1, f).keep)
+	assert.Equal(t, true, NewJob("", 1, f).SetKeepResults(true).keep)
+	f.Close()
+}
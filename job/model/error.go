@@ -1,12 +1,17 @@
 package model
 
-import "fmt"
+import (
+	"fmt"
+	"runtime/debug"
+)
 
 type strategyType int
 
 const (
 	TypeLabor strategyType = iota
 	TypeRetry
+	TypeDedup
+	TypePanic
 )
 
 func (ht *strategyType) String() string {
@@ -15,6 +20,10 @@ func (ht *strategyType) String() string {
 		return "labor"
 	case TypeRetry:
 		return "retry"
+	case TypeDedup:
+		return "dedup"
+	case TypePanic:
+		return "panic"
 	}
 	return "?"
 }
@@ -22,11 +31,33 @@ func (ht *strategyType) String() string {
 ////////////////
 // Job Error //
 type Error struct {
-	T strategyType
+	T     strategyType
+	Stack string // only for panic
 	error
 }
 
 func (je Error) Error() string {
 	return fmt.Sprintf("✗ %s failed %s", je.T.String(), je.error.Error())
 }
-func NewError(st strategyType, err error) *Error { return &Error{st, err} }
+func NewError(st strategyType, err error) *Error { return &Error{st, "", err} }
+func NewPanicError(v interface{}, stack []byte) *Error {
+	return &Error{TypePanic, string(stack), fmt.Errorf("( %+v )", v)}
+}
+
+// turn a panic in fn into a panic error so one bad item can't crash the process
+func Safely(fn func() error) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = NewPanicError(v, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+func IsDuplicate(err error) bool {
+	if je, ok := err.(*Error); ok {
+		return je.T == TypeDedup
+	} else {
+		return false
+	}
+}
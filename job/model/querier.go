@@ -0,0 +1,9 @@
+package model
+
+import "database/sql"
+
+////////////////////////////////////////////////////////////////////
+// Querier, satisfied by both database.Postgres and database.Mysql //
+type Querier interface {
+	Query(f func(db *sql.DB) error) error
+}
@@ -19,7 +19,17 @@ type Task struct {
 
 func (t *Task) run(input <-chan model.Done, output chan<- model.Done) {
 	apply := func(d model.Done, output chan<- model.Done) {
-		if r, ok := t.task(model.NewDone(d.R, nil, d.E, d.Retries, d.D, d.Key)); ok {
+		var r model.Done
+		var ok bool
+		if err := model.Safely(func() error {
+			r, ok = t.task(model.NewDone(d.R, nil, d.E, d.Retries, d.D, d.Key))
+			return nil
+		}); err != nil {
+			t.logger.Errorf("✗ task %s panic ( %+v, %s )", t.name, d, err.Error())
+			// P is P for digest
+			r, ok = model.NewDone(d.R, nil, err, d.Retries, d.D, d.Key), true
+		}
+		if ok {
 			t.logger.Debugf("✔ task %s done ( %+v )", t.name, r)
 			output <- r
 		} else {
@@ -56,3 +56,31 @@ func TestTaskWithNWorkersWithDeadline(t *testing.T) {
 	testWithNWorker(t, runtime.NumCPU(), true, false)
 	testWithNWorker(t, runtime.NumCPU(), false, false)
 }
+
+func TestTaskWithPanic(t *testing.T) {
+	data := []interface{}{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
+	f := feeder.NewDataFeeder(context.Background(), "", runtime.NumCPU(), data, 1, true)
+	output := NewTask(logging.GetLogger(""), "",
+		func(d model.Done) (model.Done, bool) {
+			if v, _ := d.P.(int); v%2 == 0 {
+				panic("test task panic")
+			}
+			return model.NewDone(nil, d.P, nil, 0, d.D, d.Key), true
+		}).Run(runtime.NumCPU(), f.Adapt())
+
+	count := 0
+	for d := range output {
+		count++
+		assert.Equal(t, true, common.IsIn(d.D, data))
+		if v, _ := d.D.(int); v%2 == 0 {
+			taskError, ok := d.E.(*model.Error)
+			assert.Equal(t, true, ok)
+			assert.Equal(t, model.TypePanic, taskError.T)
+			assert.Equal(t, nil, d.R)
+		} else {
+			assert.Equal(t, nil, d.E)
+			assert.Equal(t, d.D, d.R)
+		}
+	}
+	assert.Equal(t, len(data), count)
+}
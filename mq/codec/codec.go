@@ -0,0 +1,177 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+///////////////////////////////////////////////////////////////////////
+// Codec, picked by content type and content encoding on the way in //
+type Codec interface {
+	ContentType() string
+	ContentEncoding() string
+	Marshal(interface{}) ([]byte, error)
+	Unmarshal([]byte, interface{}) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string                   { return "application/json" }
+func (jsonCodec) ContentEncoding() string               { return "" }
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// strings & bytes as they are, anything else is taken for JSON
+type textCodec struct{}
+
+func (textCodec) ContentType() string     { return "text/plain" }
+func (textCodec) ContentEncoding() string { return "" }
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case string:
+		return []byte(t), nil
+	case []byte:
+		return t, nil
+	default:
+		return json.Marshal(v)
+	}
+}
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	switch t := v.(type) {
+	case *string:
+		*t = string(data)
+		return nil
+	case *[]byte:
+		*t = append([]byte{}, data...)
+		return nil
+	default:
+		return json.Unmarshal(data, v)
+	}
+}
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string     { return "application/x-gob" }
+func (gobCodec) ContentEncoding() string { return "" }
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type gzipCodec struct{ Codec }
+
+func (gc gzipCodec) ContentEncoding() string { return "gzip" }
+func (gc gzipCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, err := gc.Codec.Marshal(v); err != nil {
+		return nil, err
+	} else {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		} else if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+}
+func (gc gzipCodec) Unmarshal(data []byte, v interface{}) error {
+	if r, err := gzip.NewReader(bytes.NewReader(data)); err != nil {
+		return err
+	} else if data, err := ioutil.ReadAll(r); err != nil {
+		return err
+	} else {
+		return gc.Codec.Unmarshal(data, v)
+	}
+}
+
+var (
+	JSON       Codec = jsonCodec{}
+	Text       Codec = textCodec{}
+	Gob        Codec = gobCodec{}
+	GzipJSON   Codec = gzipCodec{JSON}
+	SnappyJSON Codec = snappyCodec{JSON}
+)
+
+var registry = struct {
+	sync.RWMutex
+	codecs map[string]Codec
+}{codecs: map[string]Codec{}}
+
+func keyOf(contentType, contentEncoding string) string {
+	// e.g. application/json; charset=utf-8
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.ToLower(strings.TrimSpace(contentType)) + "|" + strings.ToLower(strings.TrimSpace(contentEncoding))
+}
+
+// replaces the one of the same content type and encoding
+func Register(c Codec) {
+	registry.Lock()
+	registry.codecs[keyOf(c.ContentType(), c.ContentEncoding())] = c
+	registry.Unlock()
+}
+
+// JSON if no content type at all
+func For(contentType, contentEncoding string) (Codec, error) {
+	if contentType == "" && contentEncoding == "" {
+		return JSON, nil
+	}
+	registry.RLock()
+	c, ok := registry.codecs[keyOf(contentType, contentEncoding)]
+	registry.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no codec for ( %s, %s )", contentType, contentEncoding)
+	}
+	return c, nil
+}
+
+// bodies of text only brokers, e.g. SQS, need binary ones encoded
+func Textual(c Codec) bool {
+	return c.ContentEncoding() == "" &&
+		(strings.HasPrefix(c.ContentType(), "text/") || strings.HasSuffix(c.ContentType(), "json"))
+}
+
+// a pointer to a new value of sample's type
+func Decode(contentType, contentEncoding string, data []byte, sample interface{}) (interface{}, error) {
+	t := reflect.TypeOf(sample)
+	if t == nil {
+		return nil, fmt.Errorf("decode without a sample")
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if c, err := For(contentType, contentEncoding); err != nil {
+		return nil, err
+	} else {
+		v := reflect.New(t)
+		if err := c.Unmarshal(data, v.Interface()); err != nil {
+			return nil, fmt.Errorf("decode ( %s, %s ) failed ( %s )", contentType, contentEncoding, err.Error())
+		}
+		return v.Interface(), nil
+	}
+}
+
+func init() {
+	Register(JSON)
+	Register(Text)
+	Register(Gob)
+	Register(GzipJSON)
+	Register(SnappyJSON)
+}
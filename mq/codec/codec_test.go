@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+)
+
+type person struct {
+	Name string
+	Age  int
+}
+
+func TestCodecs(t *testing.T) {
+	for _, c := range []Codec{JSON, Text, Gob, GzipJSON, SnappyJSON} {
+		data, err := c.Marshal(person{"Lucy", 5})
+		assert.Nil(t, err)
+		v, err := Decode(c.ContentType(), c.ContentEncoding(), data, person{})
+		assert.Nil(t, err)
+		assert.Equal(t, &person{"Lucy", 5}, v)
+	}
+}
+
+func TestFor(t *testing.T) {
+	c, err := For("", "")
+	assert.Nil(t, err)
+	assert.Equal(t, JSON, c)
+	c, err = For("Application/JSON; charset=utf-8", "GZIP")
+	assert.Nil(t, err)
+	assert.Equal(t, GzipJSON, c)
+	_, err = For("text/plain", "deflate")
+	assert.NotNil(t, err)
+	_, err = Decode("application/json", "", []byte("{"), &person{})
+	assert.NotNil(t, err)
+	_, err = Decode("application/json", "", []byte("{}"), nil)
+	assert.NotNil(t, err)
+}
+
+func TestTextual(t *testing.T) {
+	assert.Equal(t, true, Textual(JSON))
+	assert.Equal(t, false, Textual(Gob))
+	assert.Equal(t, false, Textual(GzipJSON))
+	assert.Equal(t, true, Textual(Text))
+}
+
+func TestText(t *testing.T) {
+	v, err := Decode("text/plain; charset=utf-8", "", []byte("Lucy"), "")
+	assert.Nil(t, err)
+	assert.Equal(t, "Lucy", *v.(*string))
+	v, err = Decode("text/plain", "", []byte("Lucy"), []byte{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Lucy"), *v.(*[]byte))
+	data, err := Text.Marshal("Lily")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("Lily"), data)
+}
+
+func TestSnappy(t *testing.T) {
+	data, err := SnappyJSON.Marshal(bytes.Repeat([]byte("Lucy"), 1000))
+	assert.Nil(t, err)
+	json, err := snappy.Decode(nil, data)
+	assert.Nil(t, err)
+	plain, _ := JSON.Marshal(bytes.Repeat([]byte("Lucy"), 1000))
+	assert.Equal(t, plain, json)
+	assert.True(t, len(data) < len(plain))
+	_, err = Decode("application/json", "snappy", []byte("\x05\x0cabcd"), person{})
+	assert.NotNil(t, err)
+}
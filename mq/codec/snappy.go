@@ -0,0 +1,22 @@
+package codec
+
+import "github.com/golang/snappy"
+
+// snappy block format, not the framed stream one
+type snappyCodec struct{ Codec }
+
+func (sc snappyCodec) ContentEncoding() string { return "snappy" }
+func (sc snappyCodec) Marshal(v interface{}) ([]byte, error) {
+	if data, err := sc.Codec.Marshal(v); err != nil {
+		return nil, err
+	} else {
+		return snappy.Encode(nil, data), nil
+	}
+}
+func (sc snappyCodec) Unmarshal(data []byte, v interface{}) error {
+	if data, err := snappy.Decode(nil, data); err != nil {
+		return err
+	} else {
+		return sc.Codec.Unmarshal(data, v)
+	}
+}
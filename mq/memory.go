@@ -0,0 +1,130 @@
+package mq
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/samwooo/bolsa/logging"
+)
+
+// unbounded, pop blocks until a message arrives or it's closed
+type queue struct {
+	sync.Mutex
+	cond   *sync.Cond
+	msgs   []*Message
+	closed bool
+}
+
+func (q *queue) push(m *Message) {
+	q.Lock()
+	q.msgs = append(q.msgs, m)
+	q.Unlock()
+	q.cond.Signal()
+}
+
+func (q *queue) pop() (*Message, bool) {
+	q.Lock()
+	defer q.Unlock()
+	for len(q.msgs) == 0 && !q.closed {
+		q.cond.Wait()
+	}
+	if q.closed {
+		return nil, false
+	}
+	m := q.msgs[0]
+	q.msgs = q.msgs[1:]
+	return m, true
+}
+
+func (q *queue) close() {
+	q.Lock()
+	q.closed = true
+	q.Unlock()
+	q.cond.Broadcast()
+}
+
+func newQueue() *queue {
+	q := &queue{}
+	q.cond = sync.NewCond(q)
+	return q
+}
+
+////////////////////////////////////////////////////////////////////////////
+// MemoryBroker, in process for tests and local runs, subscribers of the //
+// same topic compete for messages, failed ones are retried up to a limit //
+type MemoryBroker struct {
+	sync.Mutex
+	logger     logging.Logger
+	maxRetries int
+	topics     map[string]*queue
+	closed     bool
+	wg         sync.WaitGroup
+}
+
+// subscribers join under the same lock, nobody's missed by Close
+func (b *MemoryBroker) topic(name string, subscriber bool) (*queue, error) {
+	b.Lock()
+	defer b.Unlock()
+	if b.closed {
+		return nil, fmt.Errorf("broker closed")
+	}
+	q, ok := b.topics[name]
+	if !ok {
+		q = newQueue()
+		b.topics[name] = q
+	}
+	if subscriber {
+		b.wg.Add(1)
+	}
+	return q, nil
+}
+
+func (b *MemoryBroker) Publish(m *Message) error {
+	if q, err := b.topic(m.Topic, false); err != nil {
+		return fmt.Errorf("publish ( %s ) failed ( %s )", string(m.Body), err.Error())
+	} else {
+		published := *m
+		q.push(&published)
+		return nil
+	}
+}
+
+func (b *MemoryBroker) Subscribe(topic string, handler Handler) error {
+	q, err := b.topic(topic, true)
+	if err != nil {
+		return fmt.Errorf("subscribe ( %s ) failed ( %s )", topic, err.Error())
+	}
+	go func() {
+		defer b.wg.Done()
+		for m, ok := q.pop(); ok; m, ok = q.pop() {
+			if err := handler(m); err != nil {
+				if m.Retries < b.maxRetries {
+					b.logger.Warnf("handle ( %s ) failed ( %s ), retry", string(m.Body), err.Error())
+					retried := *m
+					retried.Retries++
+					q.push(&retried)
+				} else {
+					b.logger.Errorf("handle ( %s ) failed ( %s ), drop message", string(m.Body), err.Error())
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// pending messages are dropped, it returns once every subscriber is done
+func (b *MemoryBroker) Close() error {
+	b.Lock()
+	b.closed = true
+	for _, q := range b.topics {
+		q.close()
+	}
+	b.Unlock()
+	b.wg.Wait()
+	return nil
+}
+
+func NewMemoryBroker(maxRetries int) *MemoryBroker {
+	return &MemoryBroker{logger: logging.GetLogger(" ⓠ memory "), maxRetries: maxRetries,
+		topics: make(map[string]*queue)}
+}
@@ -0,0 +1,48 @@
+package mq
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ = logging.DefaultLogger("", logging.LogLevelFrom("ERROR"), 100)
+
+func TestMemoryBroker(t *testing.T) {
+	var b Broker = NewMemoryBroker(2)
+	var mu sync.Mutex
+	received := map[string]int{}
+	done := make(chan struct{}, 10)
+	handler := func(m *Message) error {
+		mu.Lock()
+		received[string(m.Body)]++
+		mu.Unlock()
+		done <- struct{}{}
+		if string(m.Body) == "fail" {
+			return errors.New("oops")
+		}
+		return nil
+	}
+	assert.Nil(t, b.Publish(&Message{Topic: "T", Body: []byte("Lucy")}))
+	assert.Nil(t, b.Subscribe("T", handler))
+	assert.Nil(t, b.Subscribe("T", handler))
+	assert.Nil(t, b.Publish(&Message{Topic: "T", Body: []byte("Lily")}))
+	assert.Nil(t, b.Publish(&Message{Topic: "T", Body: []byte("fail")}))
+	assert.Nil(t, b.Publish(&Message{Topic: "other", Body: []byte("nobody")}))
+	// fail once and retried twice
+	for i := 0; i < 5; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("not delivered")
+		}
+	}
+	assert.Nil(t, b.Close())
+	assert.Equal(t, map[string]int{"Lucy": 1, "Lily": 1, "fail": 3}, received)
+	assert.NotNil(t, b.Publish(&Message{Topic: "T", Body: []byte("late")}))
+	assert.NotNil(t, b.Subscribe("T", handler))
+}
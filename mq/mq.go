@@ -0,0 +1,30 @@
+package mq
+
+//////////////////////////////////////////////////////////////////////////
+// Broker-agnostic messaging, a topic is whatever the broker routes by, //
+// e.g. routing key & queue name of RabbitMQ, queue url of SQS        //
+type Message struct {
+	Id      string
+	Topic   string
+	Headers map[string]interface{}
+	Body    []byte
+	Retries int // times delivered before, as far as the broker tells
+}
+
+// an error sends the message back for a retry, or to wherever the broker dead letters it
+type Handler func(*Message) error
+
+type Publisher interface {
+	Publish(*Message) error
+}
+
+// subscriptions last until the Broker is closed
+type Subscriber interface {
+	Subscribe(topic string, handler Handler) error
+}
+
+type Broker interface {
+	Publisher
+	Subscriber
+	Close() error
+}
@@ -0,0 +1,48 @@
+package rabbit
+
+import "github.com/streadway/amqp"
+
+// what's used of an AMQP channel, a fake stands in for it in tests
+type qChannel interface {
+	Qos(prefetchCount, prefetchSize int, global bool) error
+	Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool, args amqp.Table) (
+		<-chan amqp.Delivery, error)
+	Cancel(consumer string, noWait bool) error
+	Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error
+	Confirm(noWait bool) error
+	NotifyPublish(confirm chan amqp.Confirmation) chan amqp.Confirmation
+	NotifyReturn(c chan amqp.Return) chan amqp.Return
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	NotifyCancel(c chan string) chan string
+	ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool, args amqp.Table) error
+	QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool, args amqp.Table) (amqp.Queue, error)
+	QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error
+	Close() error
+}
+
+// what's used of an AMQP connection
+type qConnection interface {
+	Channel() (qChannel, error)
+	NotifyClose(c chan *amqp.Error) chan *amqp.Error
+	Close() error
+}
+
+type dialer func(url string, config amqp.Config) (qConnection, error)
+
+type amqpConnection struct{ *amqp.Connection }
+
+func (ac amqpConnection) Channel() (qChannel, error) {
+	if qChan, err := ac.Connection.Channel(); err != nil {
+		return nil, err
+	} else {
+		return qChan, nil
+	}
+}
+
+func dialAMQP(url string, config amqp.Config) (qConnection, error) {
+	if qConn, err := amqp.DialConfig(url, config); err != nil {
+		return nil, err
+	} else {
+		return amqpConnection{qConn}, nil
+	}
+}
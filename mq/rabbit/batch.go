@@ -0,0 +1,74 @@
+package rabbit
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// the whole batch is settled at once with the multiple flag
+type BatchHandler func([]*Delivery) (Decision, error)
+
+////////////////////////////////////////////////////////////////////////
+// batcher, up to size deliveries or whatever arrived within linger, //
+// deliveries beyond the retry limit are dropped out of the batch   //
+type batcher struct {
+	size    int
+	linger  time.Duration
+	limit   int
+	handler BatchHandler
+}
+
+func (b *batcher) batches(msgCh <-chan amqp.Delivery) <-chan []amqp.Delivery {
+	out := make(chan []amqp.Delivery)
+	go func() {
+		defer close(out)
+		for m := range msgCh {
+			batch := []amqp.Delivery{m}
+			timeout := time.After(b.linger)
+		collect:
+			for len(batch) < b.size {
+				select {
+				case m, ok := <-msgCh:
+					if !ok {
+						break collect
+					}
+					batch = append(batch, m)
+				case <-timeout:
+					break collect
+				}
+			}
+			out <- batch
+		}
+	}()
+	return out
+}
+
+func (b *batcher) split(batch []amqp.Delivery) (handled, dropped []amqp.Delivery) {
+	for _, m := range batch {
+		if retried := retriesFrom(m.Headers); retried > 0 && retried >= b.limit {
+			dropped = append(dropped, m)
+		} else {
+			handled = append(handled, m)
+		}
+	}
+	return
+}
+
+func (b *batcher) handle(batch []amqp.Delivery) (Decision, error) {
+	deliveries := make([]*Delivery, len(batch))
+	for i, m := range batch {
+		deliveries[i] = deliveryFrom(m)
+	}
+	return b.handler(deliveries)
+}
+
+func newBatcher(size int, linger time.Duration, limit int, handler BatchHandler) *batcher {
+	if size < 1 {
+		size = 1
+	}
+	if linger <= 0 {
+		linger = 100 * time.Millisecond
+	}
+	return &batcher{size, linger, limit, handler}
+}
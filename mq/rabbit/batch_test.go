@@ -0,0 +1,99 @@
+package rabbit
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+// records how deliveries are settled
+type acknowledger struct {
+	sync.Mutex
+	settled []string
+}
+
+func (a *acknowledger) record(op string, tag uint64, multiple bool) error {
+	a.Lock()
+	defer a.Unlock()
+	a.settled = append(a.settled, fmt.Sprintf("%s %d %t", op, tag, multiple))
+	return nil
+}
+func (a *acknowledger) Ack(tag uint64, multiple bool) error { return a.record("ack", tag, multiple) }
+func (a *acknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	return a.record(fmt.Sprintf("nack( %t )", requeue), tag, multiple)
+}
+func (a *acknowledger) Reject(tag uint64, requeue bool) error { return a.Nack(tag, false, requeue) }
+
+func deliveries(a *acknowledger, n int, headers func(int) amqp.Table) chan amqp.Delivery {
+	msgCh := make(chan amqp.Delivery, n)
+	for i := 1; i <= n; i++ {
+		msgCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: uint64(i), Headers: headers(i),
+			Body: []byte(fmt.Sprintf("%d", i))}
+	}
+	close(msgCh)
+	return msgCh
+}
+
+func TestParallelConsumerSettlesInOrder(t *testing.T) {
+	a := &acknowledger{}
+	c := newConsumer(0, "Q", 10, 0, Handler(func(d *Delivery) (Decision, error) {
+		time.Sleep(time.Duration(rand.Intn(10)) * time.Millisecond)
+		if string(d.Body) == "3" {
+			return Requeue, nil
+		}
+		return Ack, nil
+	}))
+	c.parallel = 4
+	c.dispatch(deliveries(a, 10, func(int) amqp.Table { return nil }))
+	var expected []string
+	for i := 1; i <= 10; i++ {
+		if i == 3 {
+			expected = append(expected, "nack( true ) 3 false")
+		} else {
+			expected = append(expected, fmt.Sprintf("ack %d false", i))
+		}
+	}
+	assert.Equal(t, expected, a.settled)
+}
+
+func TestBatchConsumer(t *testing.T) {
+	a := &acknowledger{}
+	var sizes []int
+	c := newBatchConsumer(0, "Q", 10, 0, 3, time.Second, func(ds []*Delivery) (Decision, error) {
+		sizes = append(sizes, len(ds))
+		if string(ds[0].Body) == "5" {
+			return Reject, fmt.Errorf("oops")
+		}
+		return Ack, nil
+	}, 2)
+	c.dispatch(deliveries(a, 8, func(i int) amqp.Table {
+		if i == 4 {
+			return amqp.Table{"x-death": []interface{}{amqp.Table{"count": int64(2)}}}
+		}
+		return nil
+	}))
+	// 4 is beyond the retry limit, dropped out of its batch
+	assert.Equal(t, []int{3, 2, 2}, sizes)
+	assert.Equal(t, []string{"ack 3 true", "ack 4 false", "nack( false ) 6 true", "ack 8 true"}, a.settled)
+}
+
+func TestBatcherLinger(t *testing.T) {
+	msgCh := make(chan amqp.Delivery)
+	b := newBatcher(10, 50*time.Millisecond, 0, nil)
+	batches := b.batches(msgCh)
+	go func() {
+		msgCh <- amqp.Delivery{}
+		msgCh <- amqp.Delivery{}
+	}()
+	start := time.Now()
+	assert.Equal(t, 2, len(<-batches))
+	assert.Equal(t, true, time.Since(start) >= 50*time.Millisecond)
+	close(msgCh)
+	_, open := <-batches
+	assert.Equal(t, false, open)
+}
@@ -0,0 +1,52 @@
+package rabbit
+
+import (
+	"github.com/samwooo/bolsa/mq"
+	"github.com/streadway/amqp"
+)
+
+////////////////////////////////////////////////////////////////////////
+// Broker, mq.Broker upon a Connection, topics are routing keys of the //
+// exchange to publish and queue names to subscribe                  //
+type Broker struct {
+	conn      *Connection
+	exchange  string
+	opts      ConsumerOptions
+	publisher *Publisher
+}
+
+func (b *Broker) Publish(m *mq.Message) error {
+	message := NewMessage(b.exchange, m.Topic, m.Body).SetProperties(amqp.Publishing{
+		ContentType: "text/plain",
+		MessageId:   m.Id,
+		Headers:     amqp.Table(m.Headers),
+	})
+	return b.publisher.Publish(message)
+}
+
+// a handler error rejects the delivery, dead lettered for a retry if the queue is set up so
+func (b *Broker) Subscribe(topic string, handler mq.Handler) error {
+	RunHandlerWith(b.conn, topic, b.opts, func(d *Delivery) (Decision, error) {
+		if err := handler(&mq.Message{Id: d.MessageId, Topic: d.RoutingKey, Headers: d.Headers, Body: d.Body,
+			Retries: d.Retries}); err != nil {
+			return Reject, err
+		}
+		return Ack, nil
+	})
+	return nil
+}
+
+// in-flight deliveries get the connection's grace period to finish
+func (b *Broker) Close() error {
+	return b.conn.terminate()
+}
+
+// at least one worker per subscription, publishing buffers up to buffer messages while disconnected
+func NewBroker(conn *Connection, exchange string, opts ConsumerOptions, buffer int) *Broker {
+	if opts.Workers < 1 {
+		opts.Workers = 1
+	}
+	return &Broker{conn, exchange, opts, NewPublisher(conn, 1, buffer)}
+}
+
+var _ mq.Broker = (*Broker)(nil)
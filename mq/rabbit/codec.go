@@ -0,0 +1,29 @@
+package rabbit
+
+import (
+	"fmt"
+
+	"github.com/samwooo/bolsa/mq/codec"
+)
+
+// content type and encoding follow the codec
+func NewEncodedMessage(exchange, routingKey string, v interface{}, c codec.Codec) (*Message, error) {
+	if body, err := c.Marshal(v); err != nil {
+		return nil, fmt.Errorf("encode ( %+v ) failed ( %s )", v, err.Error())
+	} else {
+		m := NewMessage(exchange, routingKey, body).SetContentType(c.ContentType())
+		m.ContentEncoding = c.ContentEncoding()
+		return m, nil
+	}
+}
+
+// handler gets a pointer to a new value of sample's type, undecodable deliveries are rejected
+func Decoded(sample interface{}, handler func(interface{}, *Delivery) (Decision, error)) Handler {
+	return func(d *Delivery) (Decision, error) {
+		if v, err := codec.Decode(d.ContentType, d.ContentEncoding, d.Body, sample); err != nil {
+			return Reject, err
+		} else {
+			return handler(v, d)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package rabbit
+
+import (
+	"testing"
+
+	"github.com/samwooo/bolsa/mq/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodedMessageDecoded(t *testing.T) {
+	m, err := NewEncodedMessage("E", "K", map[string]int{"Lucy": 5}, codec.GzipJSON)
+	assert.Nil(t, err)
+	assert.Equal(t, "application/json", m.ContentType)
+	assert.Equal(t, "gzip", m.ContentEncoding)
+	var decoded interface{}
+	handler := Decoded(map[string]int{}, func(v interface{}, d *Delivery) (Decision, error) {
+		decoded = v
+		return Ack, nil
+	})
+	decision, err := handler(&Delivery{ContentType: m.ContentType, ContentEncoding: m.ContentEncoding, Body: m.Body})
+	assert.Nil(t, err)
+	assert.Equal(t, Ack, decision)
+	assert.Equal(t, &map[string]int{"Lucy": 5}, decoded)
+	decision, err = handler(&Delivery{ContentType: "text/plain", Body: []byte("Lucy")})
+	assert.NotNil(t, err)
+	assert.Equal(t, Reject, decision)
+}
+
+func TestPlainMessageDecoded(t *testing.T) {
+	m := NewMessage("E", "K", []byte("Lucy"))
+	var decoded interface{}
+	decision, err := Decoded("", func(v interface{}, d *Delivery) (Decision, error) {
+		decoded = v
+		return Ack, nil
+	})(&Delivery{ContentType: m.ContentType, Body: m.Body})
+	assert.Nil(t, err)
+	assert.Equal(t, Ack, decision)
+	assert.Equal(t, "Lucy", *decoded.(*string))
+}
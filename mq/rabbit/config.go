@@ -0,0 +1,112 @@
+package rabbit
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+const (
+	defaultHeartbeat = 10 * time.Second
+	defaultLocale    = "en_US"
+)
+
+////////////////////////////////////////////////////////////////
+// TLS, CA to verify the broker, client certs if it asks for //
+type TLS struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string // the host by default
+	InsecureSkipVerify bool
+}
+
+func (t *TLS) config() (*tls.Config, error) {
+	config := &tls.Config{ServerName: t.ServerName, InsecureSkipVerify: t.InsecureSkipVerify}
+	if t.CAFile != "" {
+		if ca, err := ioutil.ReadFile(t.CAFile); err != nil {
+			return nil, fmt.Errorf("read ca ( %s ) failed ( %s )", t.CAFile, err.Error())
+		} else {
+			config.RootCAs = x509.NewCertPool()
+			if !config.RootCAs.AppendCertsFromPEM(ca) {
+				return nil, fmt.Errorf("no certificate in ca ( %s )", t.CAFile)
+			}
+		}
+	}
+	if t.CertFile != "" || t.KeyFile != "" {
+		if cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+			return nil, fmt.Errorf("load client cert ( %s, %s ) failed ( %s )", t.CertFile, t.KeyFile,
+				err.Error())
+		} else {
+			config.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return config, nil
+}
+
+/////////////////////////////////////////////////////////////////////
+// Config, either a full Uri or a Host, the rest win over the Uri //
+type Config struct {
+	Uri            string // amqp[s]://user:pass@host:port/vhost, it decides the scheme
+	Host           string // host:port, amqps once TLS is set
+	User           string
+	Password       string
+	Vhost          string
+	TLS            *TLS
+	Heartbeat      time.Duration // 10s by default
+	ConnectionName string        // shown in the management UI
+	Locale         string        // en_US by default
+}
+
+func (c Config) url() string {
+	if c.Uri != "" {
+		return c.Uri
+	}
+	u := url.URL{Scheme: "amqp", Host: c.Host, Path: "/"}
+	if c.TLS != nil {
+		u.Scheme = "amqps"
+	}
+	if c.User != "" {
+		// escaped, whatever the password has
+		u.User = url.UserPassword(c.User, c.Password)
+	}
+	return u.String()
+}
+
+// host:port, safe to log
+func (c Config) host() (string, error) {
+	if uri, err := amqp.ParseURI(c.url()); err != nil {
+		return "", fmt.Errorf("invalid uri ( %s )", err.Error())
+	} else {
+		return fmt.Sprintf("%s:%d", uri.Host, uri.Port), nil
+	}
+}
+
+func (c Config) dialConfig() (amqp.Config, error) {
+	config := amqp.Config{Vhost: c.Vhost, Heartbeat: c.Heartbeat, Locale: c.Locale}
+	if c.Uri != "" && c.User != "" {
+		config.SASL = []amqp.Authentication{&amqp.PlainAuth{Username: c.User, Password: c.Password}}
+	}
+	if config.Heartbeat <= 0 {
+		config.Heartbeat = defaultHeartbeat
+	}
+	if config.Locale == "" {
+		config.Locale = defaultLocale
+	}
+	if c.ConnectionName != "" {
+		config.Properties = amqp.Table{"connection_name": c.ConnectionName}
+	}
+	if c.TLS != nil {
+		if tlsConfig, err := c.TLS.config(); err != nil {
+			return config, err
+		} else {
+			config.TLSClientConfig = tlsConfig
+		}
+	}
+	return config, nil
+}
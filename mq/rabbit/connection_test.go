@@ -0,0 +1,36 @@
+package rabbit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestBackoff(t *testing.T) {
+	for retry := 0; retry < 100; retry++ {
+		d := backoff(retry)
+		assert.Equal(t, true, d >= minBackoff/2 && d <= maxBackoff)
+	}
+	assert.Equal(t, true, backoff(100) >= maxBackoff/2)
+}
+
+func TestConnectionState(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := NewConnection(ctx, "guest", "guest", "127.0.0.1:1")
+	assert.Equal(t, Disconnected, conn.State())
+	states := conn.NotifyState(make(chan State, 10))
+	conn.start()
+	assert.Equal(t, Connecting, <-states)
+	// keeps trying until cancelled
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, Connecting, conn.State())
+	cancel()
+	assert.Equal(t, Closed, <-states)
+	_, open := <-states
+	assert.Equal(t, false, open)
+	assert.Equal(t, Closed, conn.State())
+	_, open = <-conn.NotifyState(make(chan State, 1))
+	assert.Equal(t, false, open)
+}
@@ -1,53 +1,225 @@
 package rabbit
 
 import (
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/samwooo/bolsa/logging"
 	"github.com/streadway/amqp"
+	"golang.org/x/net/context"
 )
 
-type MessageHandler func(amqp.Table, []byte) error
-
-func (mh MessageHandler) handle(headers amqp.Table, body []byte) error { return mh(headers, body) }
-
 type consumer struct {
+	sync.Mutex
 	Id            int
 	logger        logging.Logger
-	qChan         *amqp.Channel
+	qChan         qChannel
 	qName         string
 	prefetchCount int
 	prefetchSize  int
-	handler       MessageHandler
+	handler       handler
+	topology      *Topology
+	parallel      int           // deliveries handled at once, settled in order though
+	batcher       *batcher      // batch mode if any
+	tag           string        // of the current consume
+	consuming     chan struct{} // closed once the current consume returns
+	draining      bool
+}
+
+// deliveries handled together, settled in the order they were delivered
+type work struct {
+	deliveries []amqp.Delivery
+	dropped    []amqp.Delivery
+	decision   Decision
+	err        error
+	done       chan struct{}
+}
+
+func (c *consumer) handle(w *work) {
+	if c.batcher == nil {
+		w.decision, w.err = c.handler.handle(deliveryFrom(w.deliveries[0]))
+	} else if w.deliveries, w.dropped = c.batcher.split(w.deliveries); len(w.deliveries) > 0 {
+		w.decision, w.err = c.batcher.handle(w.deliveries)
+	}
+	close(w.done)
+}
+
+func (c *consumer) settle(w *work) {
+	<-w.done
+	for _, m := range w.dropped {
+		c.logger.Warnf("( %d ) reached retry limit ( %d ) drop message", c.Id, c.batcher.limit)
+		if e := m.Ack(false); e != nil {
+			c.logger.Errorf("( %d ) ack ( %s ) failed ( %s )", c.Id, string(m.Body), e.Error())
+		}
+	}
+	if len(w.deliveries) == 0 {
+		return
+	}
+	// every delivery ahead of it has been settled, the multiple flag covers only this batch
+	last, multiple := w.deliveries[len(w.deliveries)-1], len(w.deliveries) > 1
+	if w.err != nil {
+		c.logger.Errorf("( %d ) handle ( %d ) message ( %s ) failed ( %s )", c.Id, len(w.deliveries),
+			string(last.Body), w.err.Error())
+	} else {
+		c.logger.Debugf("( %d ) handle ( %d ) message ( %s ) succeed", c.Id, len(w.deliveries), string(last.Body))
+	}
+	if e := w.decision.settle(last, multiple); e != nil {
+		c.logger.Errorf("( %d ) %s ( %s ) failed ( %s )", c.Id, w.decision, string(last.Body), e.Error())
+	}
+}
+
+func (c *consumer) batches(msgCh <-chan amqp.Delivery) <-chan []amqp.Delivery {
+	if c.batcher != nil {
+		return c.batcher.batches(msgCh)
+	}
+	out := make(chan []amqp.Delivery)
+	go func() {
+		defer close(out)
+		for m := range msgCh {
+			out <- []amqp.Delivery{m}
+		}
+	}()
+	return out
+}
+
+// up to parallel works in flight, a single settler acks them in delivery order
+func (c *consumer) dispatch(msgCh <-chan amqp.Delivery) {
+	parallel := c.parallel
+	if parallel < 1 {
+		parallel = 1
+	}
+	works, ordered := make(chan *work), make(chan *work, parallel)
+	var wg sync.WaitGroup
+	wg.Add(parallel)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			defer wg.Done()
+			for w := range works {
+				c.handle(w)
+			}
+		}()
+	}
+	settled := make(chan struct{})
+	go func() {
+		for w := range ordered {
+			c.settle(w)
+		}
+		close(settled)
+	}()
+	for deliveries := range c.batches(msgCh) {
+		w := &work{deliveries: deliveries, done: make(chan struct{})}
+		ordered <- w
+		works <- w
+	}
+	close(works)
+	close(ordered)
+	wg.Wait()
+	<-settled
+}
+
+// the one currently consuming upon if any
+func (c *consumer) channel() qChannel {
+	c.Lock()
+	defer c.Unlock()
+	return c.qChan
+}
+
+func (c *consumer) isDraining() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.draining
 }
 
-func (c *consumer) consume(qChan *amqp.Channel) {
+// true once consuming started, it returns when the channel is closed or the consumer is cancelled
+func (c *consumer) consume(qChan qChannel) bool {
+	c.Lock()
 	if c.qChan != nil {
 		c.qChan.Close()
 	}
-	c.qChan = qChan
-	if msgCh, err := c.qChan.Consume(c.qName,
-		"",
+	if c.draining {
+		c.qChan = nil
+		c.Unlock()
+		qChan.Close()
+		return false
+	}
+	c.qChan, c.tag, c.consuming = qChan, fmt.Sprintf("%s.%d.%s", c.qName, c.Id, correlationId()), make(chan struct{})
+	tag, consuming := c.tag, c.consuming
+	c.Unlock()
+	defer close(consuming)
+	closed := qChan.NotifyClose(make(chan *amqp.Error, 1))
+	cancelled := qChan.NotifyCancel(make(chan string, 1))
+	if msgCh, err := qChan.Consume(c.qName,
+		tag,
 		false,
 		false,
 		false,
 		false, nil); err != nil {
 		c.logger.Errorf("( %d ) consume failed ( %s )", c.Id, err.Error())
+		return false
 	} else {
-		for m := range msgCh {
-			if err := c.handler.handle(m.Headers, m.Body); err != nil {
-				c.logger.Errorf("( %d ) handle message ( %s ) failed ( %s )", c.Id, string(m.Body), err.Error())
-				if e := m.Nack(false, false); e != nil {
-					c.logger.Errorf("( %d ) nack ( %s ) failed ( %s )", c.Id, string(m.Body), e.Error())
-				}
+		c.dispatch(msgCh)
+		select {
+		case tag := <-cancelled:
+			c.logger.Warnf("( %d ) consumer ( %s ) cancelled by broker", c.Id, tag)
+		case err := <-closed:
+			if err != nil {
+				c.logger.Warnf("( %d ) channel closed ( %s )", c.Id, err.Error())
+			}
+		default:
+		}
+		return true
+	}
+}
+
+// reopens the channel as long as the connection lives, e.g. a cancelled consumer or a queue not declared yet
+func (c *consumer) serve(conn *Connection) {
+	for retry := 0; conn.State() == Connected && !c.isDraining(); {
+		if c.topology != nil {
+			if err := c.topology.declareUpon(conn); err != nil {
+				c.logger.Errorf("( %d ) %s", c.Id, err.Error())
+			}
+		}
+		if qChan, err := conn.channel(c.prefetchCount, c.prefetchSize); err != nil {
+			c.logger.Errorf("( %d ) %s", c.Id, err.Error())
+			retry++
+		} else {
+			c.logger.Infof("( %d ) channel refreshed", c.Id)
+			if c.consume(qChan) {
+				retry = 0
 			} else {
-				c.logger.Debugf("( %d ) handle message ( %s ) succeed", c.Id, string(m.Body))
-				if e := m.Ack(false); e != nil {
-					c.logger.Errorf("( %d ) ack ( %s ) failed ( %s )", c.Id, string(m.Body), e.Error())
-				}
+				retry++
 			}
 		}
+		if !conn.pause(backoff(retry)) {
+			return
+		}
+	}
+}
+
+// no more deliveries, in-flight ones get handled and settled up to ctx, then the channel is closed,
+// whatever is left unsettled the broker requeues
+func (c *consumer) drain(ctx context.Context) (err error) {
+	c.Lock()
+	c.draining = true
+	qChan, tag, consuming := c.qChan, c.tag, c.consuming
+	c.Unlock()
+	if qChan == nil {
+		return nil
+	}
+	if consuming != nil {
+		if e := qChan.Cancel(tag, false); e != nil {
+			c.logger.Warnf("( %d ) cancel ( %s ) failed ( %s )", c.Id, tag, e.Error())
+		}
+		select {
+		case <-consuming:
+			c.logger.Infof("( %d ) drained", c.Id)
+		case <-ctx.Done():
+			err = fmt.Errorf("( %d ) drain ( %s ) failed ( %s )", c.Id, tag, ctx.Err().Error())
+		}
 	}
+	qChan.Close()
+	return
 }
 
 func (c *consumer) run(conn *Connection) {
@@ -60,30 +232,22 @@ func (c *consumer) run(conn *Connection) {
 				return
 			case <-reconnecting:
 				c.logger.Infof("( %d ) wait for reconnecting", c.Id)
-				time.Sleep(50 * time.Millisecond)
 			case <-connected:
-				if qChan, err := conn.channel(c.prefetchCount, c.prefetchSize); err != nil {
-					c.logger.Errorf("( %d ) %s", c.Id, err.Error())
-				} else {
-					c.logger.Infof("( %d ) channel refreshed", c.Id)
-					c.consume(qChan)
-				}
-			default:
-				time.Sleep(50 * time.Millisecond)
+				c.serve(conn)
 			}
 		}
 	}(connected, reconnecting, closed)
 }
 
 func newConsumer(id int, qName string, prefetchCount, prefetchSize int,
-	handler MessageHandler) *consumer {
-	return &consumer{id, logging.GetLogger(" ⓠ " + qName + " "), nil, qName,
-		prefetchCount, prefetchSize, handler}
+	handler handler) *consumer {
+	return &consumer{Id: id, logger: logging.GetLogger(" ⓠ " + qName + " "), qName: qName,
+		prefetchCount: prefetchCount, prefetchSize: prefetchSize, handler: handler, parallel: 1}
 }
 
 //////////////////////////////////
 // Retryable RabbitMQ Consumer //
-// Requires a bit of Ops works:
+// Requires a bit of Ops works, or simply RunConsumerWithTopology upon RetryTopology:
 // 1: Topic Exchange=E with Qs
 // 2: Each Q has DLX=DLE and DLK but NOT TTL which msg will be move into DLE immediately by calling Nack(requeue:false)
 // 3: DL Topic Exchange=DLE with DLQs
@@ -93,26 +257,66 @@ type retryableConsumer struct {
 	limit int
 }
 
-func newRetryableConsumer(id int, qName string, prefetchCount, prefetchSize int, handler MessageHandler,
+func newRetryableConsumer(id int, qName string, prefetchCount, prefetchSize int, handler handler,
 	limit int) *retryableConsumer {
 	logger := logging.GetLogger(" ⓠ " + qName + " ")
-	retryableHandler := func() MessageHandler {
-		return func(headers amqp.Table, body []byte) error {
-			if xDeaths, ok := headers["x-death"]; ok {
-				xDeaths, _ := xDeaths.([]interface{})
-				xDeath, _ := xDeaths[0].(amqp.Table)
-				retried, _ := xDeath["count"].(int64)
-				if int(retried) >= limit {
-					logger.Warnf("( %d ) reached retry limit ( %d ) drop message", id, limit)
-					return nil
-				} else {
-					return handler.handle(headers, body)
-				}
+	retryableHandler := func() Handler {
+		return func(d *Delivery) (Decision, error) {
+			if d.Retries > 0 && d.Retries >= limit {
+				logger.Warnf("( %d ) reached retry limit ( %d ) drop message", id, limit)
+				return Ack, nil
 			} else {
-				return handler.handle(headers, body)
+				return handler.handle(d)
 			}
 		}
 	}
 	return &retryableConsumer{
 		newConsumer(id, qName, prefetchCount, prefetchSize, retryableHandler()), limit}
 }
+
+// rejected deliveries are scheduled by policy rather than dead lettered, requeued if the broker
+// doesn't confirm the republish
+func newScheduledConsumer(conn *Connection, id int, qName string, prefetchCount, prefetchSize int,
+	handler handler, policy *RetryPolicy) *retryableConsumer {
+	limit := policy.limit()
+	c := &retryableConsumer{newConsumer(id, qName, prefetchCount, prefetchSize, nil), limit}
+	r := newRepublisher(conn, policy.Timeout)
+	park := func(d *Delivery) (Decision, error) {
+		if parked, err := policy.park(r, d); err != nil {
+			return Requeue, err
+		} else if parked {
+			c.logger.Warnf("( %d ) reached retry limit ( %d ) park message", id, limit)
+		} else {
+			c.logger.Warnf("( %d ) reached retry limit ( %d ) drop message", id, limit)
+		}
+		return Ack, nil
+	}
+	c.handler = Handler(func(d *Delivery) (Decision, error) {
+		// handled once plus limit retries, or dead lettered past it elsewhere
+		if d.Retries > limit {
+			return park(d)
+		}
+		decision, err := handler.handle(d)
+		if decision != Reject {
+			return decision, err
+		} else if d.Retries >= limit {
+			if decision, e := park(d); e != nil {
+				return decision, e
+			}
+			return Ack, err
+		} else if e := policy.schedule(r, qName, d); e != nil {
+			c.logger.Errorf("( %d ) %s", id, e.Error())
+			return Requeue, err
+		}
+		return Ack, err
+	})
+	return c
+}
+
+// deliveries beyond limit are acked and dropped out of the batch ahead of handling
+func newBatchConsumer(id int, qName string, prefetchCount, prefetchSize int, size int, linger time.Duration,
+	handler BatchHandler, limit int) *consumer {
+	c := newConsumer(id, qName, prefetchCount, prefetchSize, nil)
+	c.batcher = newBatcher(size, linger, limit, handler)
+	return c
+}
@@ -0,0 +1,225 @@
+package rabbit
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// what a handler got, in order
+type handled struct {
+	sync.Mutex
+	deliveries []*Delivery
+}
+
+func (h *handled) add(d *Delivery) {
+	h.Lock()
+	h.deliveries = append(h.deliveries, d)
+	h.Unlock()
+}
+
+func (h *handled) count() int {
+	h.Lock()
+	defer h.Unlock()
+	return len(h.deliveries)
+}
+
+func (h *handled) bodies() (bodies []string) {
+	h.Lock()
+	defer h.Unlock()
+	for _, d := range h.deliveries {
+		bodies = append(bodies, string(d.Body))
+	}
+	return
+}
+
+func TestConsumerRetriesUpToLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.deadLetters = true
+	h := &handled{}
+	RunHandlerUpon(newFakeConnection(ctx, fb), nil, "Q", 1, 0, 3, 1, func(d *Delivery) (Decision, error) {
+		h.add(d)
+		return Reject, errors.New("oops")
+	})
+	fb.push("Q", nil, "Lucy")
+	eventually(t, func() bool { return len(fb.records()) == 4 })
+	assert.Equal(t, []string{"reject Lucy", "reject Lucy", "reject Lucy", "ack Lucy"}, fb.records())
+	assert.Equal(t, 3, h.count())
+	for i, d := range h.deliveries {
+		assert.Equal(t, i, d.Retries)
+	}
+}
+
+func TestConsumerDropsInjectedXDeath(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	h := &handled{}
+	RunConsumerUpon(newFakeConnection(ctx, fb), "Q", 1, 0, 2, 1, func(headers amqp.Table, body []byte) error {
+		h.add(&Delivery{Body: body})
+		return nil
+	})
+	fb.push("Q", amqp.Table{"x-death": []interface{}{amqp.Table{}}}, "Lucy")
+	fb.push("Q", retried(1), "Lily")
+	fb.push("Q", retried(2), "Lulu")
+	eventually(t, func() bool { return len(fb.records()) == 3 })
+	assert.Equal(t, []string{"Lucy", "Lily"}, h.bodies())
+	assert.Equal(t, []string{"ack Lucy", "ack Lily", "ack Lulu"}, fb.records())
+}
+
+func TestConsumerRequeue(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	h := &handled{}
+	RunHandlerUpon(newFakeConnection(ctx, fb), nil, "Q", 1, 0, 0, 1, func(d *Delivery) (Decision, error) {
+		h.add(d)
+		if d.Redelivered {
+			return Ack, nil
+		}
+		return Requeue, nil
+	})
+	fb.push("Q", nil, "Lucy")
+	eventually(t, func() bool { return len(fb.records()) == 2 })
+	assert.Equal(t, []string{"requeue Lucy", "ack Lucy"}, fb.records())
+}
+
+func TestConsumerReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.refuse = 2
+	conn := newFakeConnection(ctx, fb)
+	states := conn.NotifyState(make(chan State, 10))
+	h := &handled{}
+	RunHandlerUpon(conn, nil, "Q", 1, 0, 0, 2, func(d *Delivery) (Decision, error) {
+		h.add(d)
+		return Ack, nil
+	})
+	fb.push("Q", nil, "Lucy")
+	eventually(t, func() bool { return h.count() == 1 })
+	fb.drop()
+	fb.push("Q", nil, "Lily")
+	eventually(t, func() bool { return h.count() == 2 })
+	assert.Equal(t, []string{"Lucy", "Lily"}, h.bodies())
+	assert.Equal(t, 4, fb.dials)
+	assert.Equal(t, Connecting, <-states)
+	assert.Equal(t, Connected, <-states)
+	assert.Equal(t, Reconnecting, <-states)
+	assert.Equal(t, Connected, <-states)
+}
+
+func TestConsumerRecoversChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	// the queue isn't there at first
+	fb.fail = 2
+	h := &handled{}
+	RunHandlerUpon(newFakeConnection(ctx, fb), nil, "Q", 1, 0, 0, 1, func(d *Delivery) (Decision, error) {
+		h.add(d)
+		return Ack, nil
+	})
+	fb.push("Q", nil, "Lucy")
+	eventually(t, func() bool { return h.count() == 1 })
+	fb.cancel("Q")
+	fb.push("Q", nil, "Lily")
+	eventually(t, func() bool { return h.count() == 2 })
+	assert.Equal(t, []string{"Lucy", "Lily"}, h.bodies())
+}
+
+func TestPublisherFlushesOnceConnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.refuse = 1
+	p := NewConfirmPublisher(newFakeConnection(ctx, fb), 1, 10, time.Second)
+	for _, name := range []string{"Lucy", "Lily", "Lulu"} {
+		assert.Nil(t, p.Publish(NewMessage("", "Q", []byte(name))))
+	}
+	eventually(t, func() bool { return len(fb.queue("Q")) == 3 })
+	assert.Nil(t, p.PublishSync(NewMessage("", "Q", []byte("Lala"))))
+	assert.Equal(t, 4, len(fb.queue("Q")))
+}
+
+func TestCallServer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn := newFakeConnection(ctx, newFakeBroker())
+	RunServerUpon(conn, "rpc", 1, 0, 1, func(d *Delivery) ([]byte, error) {
+		if string(d.Body) == "fail" {
+			return nil, errors.New("oops")
+		}
+		return []byte(strings.ToUpper(string(d.Body))), nil
+	})
+	c := NewCaller(conn, time.Second)
+	eventually(t, func() bool { return conn.State() == Connected })
+	var reply []byte
+	var err error
+	eventually(t, func() bool {
+		reply, err = c.Call(ctx, "", "rpc", []byte("lucy"))
+		return err == nil
+	})
+	assert.Equal(t, "LUCY", string(reply))
+	_, err = c.Call(ctx, "", "rpc", []byte("fail"))
+	assert.NotNil(t, err)
+}
+
+func retried(count int64) amqp.Table {
+	return amqp.Table{"x-death": []interface{}{amqp.Table{"count": count}}}
+}
+
+func TestShutdownDrainsInFlight(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	conn := newFakeConnection(ctx, fb)
+	started, release := make(chan struct{}), make(chan struct{})
+	RunHandlerUpon(conn, nil, "Q", 1, 0, 0, 1, func(d *Delivery) (Decision, error) {
+		close(started)
+		<-release
+		return Ack, nil
+	})
+	fb.push("Q", nil, "Lucy")
+	<-started
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), time.Second)
+	defer cancelShutdown()
+	done := conn.Shutdown(shutdown)
+	close(release)
+	assert.NoError(t, <-done)
+	assert.Equal(t, []string{"ack Lucy"}, fb.records())
+	assert.Equal(t, Closed, conn.State())
+}
+
+func TestShutdownDeadlineRequeues(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	conn := newFakeConnection(ctx, fb)
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	RunHandlerUpon(conn, nil, "Q", 1, 0, 0, 1, func(d *Delivery) (Decision, error) {
+		if !d.Redelivered {
+			close(started)
+		}
+		<-release
+		return Ack, nil
+	})
+	fb.push("Q", nil, "Lucy")
+	<-started
+	shutdown, cancelShutdown := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShutdown()
+	assert.Error(t, <-conn.Shutdown(shutdown))
+	assert.Equal(t, Closed, conn.State())
+	m := <-fb.queue("Q")
+	assert.Equal(t, "Lucy", string(m.Body))
+	assert.True(t, m.Redelivered)
+}
@@ -0,0 +1,124 @@
+package rabbit
+
+import (
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+///////////////////////////////////////////////////////////////////
+// Delivery, what a handler sees, Retries is counted by x-retries  //
+// if a RetryPolicy scheduled it, or by x-death                   //
+type Delivery struct {
+	Exchange        string
+	RoutingKey      string
+	MessageId       string
+	CorrelationId   string
+	ReplyTo         string
+	ContentType     string
+	ContentEncoding string
+	Priority        uint8
+	Type            string
+	AppId           string
+	Expiration      string
+	Timestamp       time.Time
+	Redelivered     bool
+	Retries         int
+	Headers         amqp.Table
+	Body            []byte
+}
+
+// times the message has been scheduled or dead lettered, most recent x-death first
+func retriesFrom(headers amqp.Table) int {
+	switch retried := headers[retriesHeader].(type) {
+	case int64:
+		return int(retried)
+	case int32:
+		return int(retried)
+	case int:
+		return retried
+	}
+	if xDeaths, ok := headers["x-death"].([]interface{}); ok && len(xDeaths) > 0 {
+		if xDeath, ok := xDeaths[0].(amqp.Table); ok {
+			retried, _ := xDeath["count"].(int64)
+			return int(retried)
+		}
+	}
+	return 0
+}
+
+func deliveryFrom(m amqp.Delivery) *Delivery {
+	return &Delivery{
+		Exchange:        m.Exchange,
+		RoutingKey:      m.RoutingKey,
+		MessageId:       m.MessageId,
+		CorrelationId:   m.CorrelationId,
+		ReplyTo:         m.ReplyTo,
+		ContentType:     m.ContentType,
+		ContentEncoding: m.ContentEncoding,
+		Priority:        m.Priority,
+		Type:            m.Type,
+		AppId:           m.AppId,
+		Expiration:      m.Expiration,
+		Timestamp:       m.Timestamp,
+		Redelivered:     m.Redelivered,
+		Retries:         retriesFrom(m.Headers),
+		Headers:         m.Headers,
+		Body:            m.Body,
+	}
+}
+
+//////////////////////////////////////////////////////////
+// Decision, how a delivery is settled once it's handled //
+type Decision int
+
+const (
+	Ack     Decision = iota // done with it
+	Requeue                 // back to the queue, delivered again soon
+	Reject                  // dead lettered, e.g. into a retry queue
+)
+
+func (d Decision) String() string {
+	switch d {
+	case Ack:
+		return "ack"
+	case Requeue:
+		return "requeue"
+	case Reject:
+		return "reject"
+	default:
+		return "unknown"
+	}
+}
+
+// multiple settles every unsettled delivery up to m on its channel
+func (d Decision) settle(m amqp.Delivery, multiple bool) error {
+	switch d {
+	case Requeue:
+		return m.Nack(multiple, true)
+	case Reject:
+		return m.Nack(multiple, false)
+	default:
+		return m.Ack(multiple)
+	}
+}
+
+type handler interface {
+	handle(*Delivery) (Decision, error)
+}
+
+// acked on success, rejected otherwise
+type MessageHandler func(amqp.Table, []byte) error
+
+func (mh MessageHandler) handle(d *Delivery) (Decision, error) {
+	if err := mh(d.Headers, d.Body); err != nil {
+		return Reject, err
+	} else {
+		return Ack, nil
+	}
+}
+
+// settled as decided, the error is only logged
+type Handler func(*Delivery) (Decision, error)
+
+func (h Handler) handle(d *Delivery) (Decision, error) { return h(d) }
@@ -0,0 +1,47 @@
+package rabbit
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeliveryFrom(t *testing.T) {
+	d := deliveryFrom(amqp.Delivery{Exchange: "E", RoutingKey: "K", MessageId: "M", CorrelationId: "C",
+		ReplyTo: "R", Redelivered: true, Body: []byte("body"),
+		Headers: amqp.Table{"x-death": []interface{}{amqp.Table{"count": int64(2)}, amqp.Table{"count": int64(1)}}}})
+	assert.Equal(t, "E", d.Exchange)
+	assert.Equal(t, "K", d.RoutingKey)
+	assert.Equal(t, "M", d.MessageId)
+	assert.Equal(t, "C", d.CorrelationId)
+	assert.Equal(t, "R", d.ReplyTo)
+	assert.Equal(t, true, d.Redelivered)
+	assert.Equal(t, 2, d.Retries)
+	assert.Equal(t, []byte("body"), d.Body)
+	assert.Equal(t, 0, retriesFrom(amqp.Table{"x-death": []interface{}{}}))
+	assert.Equal(t, 0, retriesFrom(nil))
+}
+
+func TestMessageHandlerDecision(t *testing.T) {
+	decision, err := MessageHandler(func(amqp.Table, []byte) error { return nil }).handle(&Delivery{})
+	assert.Equal(t, Ack, decision)
+	assert.Nil(t, err)
+	decision, err = MessageHandler(func(amqp.Table, []byte) error { return errors.New("oops") }).handle(&Delivery{})
+	assert.Equal(t, Reject, decision)
+	assert.NotNil(t, err)
+}
+
+func TestRetryableHandler(t *testing.T) {
+	handled := 0
+	c := newRetryableConsumer(0, "Q", 1, 0, Handler(func(d *Delivery) (Decision, error) {
+		handled++
+		return Requeue, nil
+	}), 3)
+	decision, _ := c.handler.handle(&Delivery{Retries: 2})
+	assert.Equal(t, Requeue, decision)
+	decision, _ = c.handler.handle(&Delivery{Retries: 3})
+	assert.Equal(t, Ack, decision)
+	assert.Equal(t, 1, handled)
+}
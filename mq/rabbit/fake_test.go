@@ -0,0 +1,527 @@
+package rabbit
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"golang.org/x/net/context"
+)
+
+/////////////////////////////////////////////////////////////////////////////
+// fakeBroker, an in process stand-in of RabbitMQ, exchanges are ignored, //
+// messages go to the queue named by the routing key, rejected ones come  //
+// back to their queue at once with x-death counted if deadLetters is set //
+type fakeBroker struct {
+	sync.Mutex
+	queues      map[string]chan amqp.Delivery
+	conns       []*fakeConnection
+	refuse      int  // dials refused ahead
+	fail        int  // consumes failed ahead, as if the queue weren't declared
+	broken      int  // publishes failed ahead, their channel closed by the broker
+	nacked      int  // confirms nacked ahead
+	muted       int  // confirms never sent ahead, as if the broker hung
+	deadLetters bool // rejected messages retried through a dead letter queue
+	dials       int
+	settled     []string
+}
+
+func (fb *fakeBroker) queue(name string) chan amqp.Delivery {
+	fb.Lock()
+	defer fb.Unlock()
+	q, ok := fb.queues[name]
+	if !ok {
+		q = make(chan amqp.Delivery, 1000)
+		fb.queues[name] = q
+	}
+	return q
+}
+
+func (fb *fakeBroker) publish(queue string, m amqp.Delivery) {
+	m.DeliveryTag, m.Acknowledger, m.ConsumerTag = 0, nil, ""
+	fb.queue(queue) <- m
+}
+
+// mandatory messages to a queue never declared nor published to come back
+func (fb *fakeBroker) routable(queue string) bool {
+	fb.Lock()
+	defer fb.Unlock()
+	_, ok := fb.queues[queue]
+	return ok
+}
+
+// as if queue were never declared, messages to it are unroutable
+func (fb *fakeBroker) unbind(queue string) {
+	fb.Lock()
+	defer fb.Unlock()
+	delete(fb.queues, queue)
+}
+
+// e.g. x-death headers of a message retried before
+func (fb *fakeBroker) push(queue string, headers amqp.Table, body string) {
+	fb.publish(queue, amqp.Delivery{RoutingKey: queue, Headers: headers, Body: []byte(body)})
+}
+
+func (fb *fakeBroker) record(op string, m amqp.Delivery) {
+	fb.Lock()
+	fb.settled = append(fb.settled, fmt.Sprintf("%s %s", op, string(m.Body)))
+	fb.Unlock()
+}
+
+func (fb *fakeBroker) records() []string {
+	fb.Lock()
+	defer fb.Unlock()
+	return append([]string{}, fb.settled...)
+}
+
+func (fb *fakeBroker) deadLetter(queue string, m amqp.Delivery) {
+	if !fb.deadLetters {
+		return
+	}
+	count := int64(retriesFrom(m.Headers))
+	headers := amqp.Table{}
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers["x-death"] = []interface{}{amqp.Table{"count": count + 1, "queue": queue, "reason": "rejected"}}
+	m.Headers, m.Redelivered = headers, false
+	fb.publish(queue, m)
+}
+
+func (fb *fakeBroker) dial(url string, config amqp.Config) (qConnection, error) {
+	fb.Lock()
+	defer fb.Unlock()
+	fb.dials++
+	if fb.refuse > 0 {
+		fb.refuse--
+		return nil, fmt.Errorf("dial ( %s ) refused", url)
+	}
+	conn := &fakeConnection{broker: fb}
+	fb.conns = append(fb.conns, conn)
+	return conn, nil
+}
+
+// as if the broker went away, every connection and its channels dropped
+func (fb *fakeBroker) drop() {
+	fb.Lock()
+	conns := append([]*fakeConnection{}, fb.conns...)
+	fb.Unlock()
+	for _, conn := range conns {
+		conn.shutdown(&amqp.Error{Code: amqp.ConnectionForced, Reason: "CONNECTION_FORCED"})
+	}
+}
+
+// as if the queue were deleted, consumers of it cancelled by the broker
+func (fb *fakeBroker) cancel(queue string) {
+	fb.Lock()
+	conns := append([]*fakeConnection{}, fb.conns...)
+	fb.Unlock()
+	for _, conn := range conns {
+		for _, ch := range conn.opened() {
+			ch.cancelled(queue)
+		}
+	}
+}
+
+// one of ahead used up if any
+func (fb *fakeBroker) next(ahead *int) bool {
+	fb.Lock()
+	defer fb.Unlock()
+	if *ahead > 0 {
+		*ahead--
+		return true
+	}
+	return false
+}
+
+func (fb *fakeBroker) failing() bool { return fb.next(&fb.fail) }
+
+func newFakeBroker() *fakeBroker {
+	return &fakeBroker{queues: make(map[string]chan amqp.Delivery)}
+}
+
+// a Connection upon fb, it dials once started
+func newFakeConnection(ctx context.Context, fb *fakeBroker) *Connection {
+	c := newConnection(ctx, "amqp://fake/", "fake", amqp.Config{})
+	c.dialer = fb.dial
+	return c
+}
+
+type fakeConnection struct {
+	sync.Mutex
+	broker   *fakeBroker
+	channels []*fakeChannel
+	closes   []chan *amqp.Error
+	closed   bool
+}
+
+func (fc *fakeConnection) opened() []*fakeChannel {
+	fc.Lock()
+	defer fc.Unlock()
+	return append([]*fakeChannel{}, fc.channels...)
+}
+
+func (fc *fakeConnection) Channel() (qChannel, error) {
+	fc.Lock()
+	defer fc.Unlock()
+	if fc.closed {
+		return nil, amqp.ErrClosed
+	}
+	ch := &fakeChannel{conn: fc, unsettled: make(map[uint64]fakeDelivery),
+		consumers: make(map[string]fakeConsumer)}
+	fc.channels = append(fc.channels, ch)
+	return ch, nil
+}
+
+func (fc *fakeConnection) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	fc.Lock()
+	defer fc.Unlock()
+	if fc.closed {
+		close(c)
+	} else {
+		fc.closes = append(fc.closes, c)
+	}
+	return c
+}
+
+func (fc *fakeConnection) Close() error {
+	fc.shutdown(nil)
+	return nil
+}
+
+func (fc *fakeConnection) shutdown(err *amqp.Error) {
+	fc.Lock()
+	if fc.closed {
+		fc.Unlock()
+		return
+	}
+	fc.closed = true
+	channels, closes := fc.channels, fc.closes
+	fc.Unlock()
+	for _, ch := range channels {
+		ch.shutdown(err)
+	}
+	for _, c := range closes {
+		if err != nil {
+			c <- err
+		}
+		close(c)
+	}
+}
+
+type fakeDelivery struct {
+	queue string
+	amqp.Delivery
+}
+
+type fakeConsumer struct {
+	queue string
+	stop  chan struct{}
+}
+
+type fakeChannel struct {
+	sync.Mutex
+	conn      *fakeConnection
+	tag       uint64
+	published uint64
+	confirm   bool
+	unsettled map[uint64]fakeDelivery
+	consumers map[string]fakeConsumer
+	closes    []chan *amqp.Error
+	cancels   []chan string
+	confirms  []chan amqp.Confirmation
+	returns   []chan amqp.Return
+	closed    bool
+}
+
+func (ch *fakeChannel) forward(queue, tag string, autoAck bool, stop chan struct{}, out chan amqp.Delivery) {
+	defer close(out)
+	q := ch.conn.broker.queue(queue)
+	for {
+		select {
+		case <-stop:
+			return
+		case m := <-q:
+			ch.Lock()
+			if ch.closed {
+				ch.Unlock()
+				q <- m
+				return
+			}
+			ch.tag++
+			m.DeliveryTag, m.Acknowledger, m.ConsumerTag = ch.tag, ch, tag
+			if !autoAck {
+				ch.unsettled[ch.tag] = fakeDelivery{queue, m}
+			}
+			ch.Unlock()
+			select {
+			case out <- m:
+			case <-stop:
+				// unsettled, back to the queue once the channel closes
+				return
+			}
+		}
+	}
+}
+
+func (ch *fakeChannel) Consume(queue, consumer string, autoAck, exclusive, noLocal, noWait bool,
+	args amqp.Table) (<-chan amqp.Delivery, error) {
+	if ch.conn.broker.failing() {
+		ch.shutdown(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no queue " + queue})
+		return nil, fmt.Errorf("consume ( %s ) failed, not found", queue)
+	}
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	if consumer == "" {
+		consumer = fmt.Sprintf("ctag-%d", len(ch.consumers)+1)
+	}
+	out, stop := make(chan amqp.Delivery), make(chan struct{})
+	ch.consumers[consumer] = fakeConsumer{queue, stop}
+	go ch.forward(queue, consumer, autoAck, stop, out)
+	return out, nil
+}
+
+func (ch *fakeChannel) Cancel(consumer string, noWait bool) error {
+	ch.Lock()
+	defer ch.Unlock()
+	if c, ok := ch.consumers[consumer]; ok {
+		close(c.stop)
+		delete(ch.consumers, consumer)
+	}
+	return nil
+}
+
+// by the broker
+func (ch *fakeChannel) cancelled(queue string) {
+	ch.Lock()
+	defer ch.Unlock()
+	for tag, c := range ch.consumers {
+		if c.queue == queue {
+			close(c.stop)
+			delete(ch.consumers, tag)
+			for _, cancel := range ch.cancels {
+				select {
+				case cancel <- tag:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (ch *fakeChannel) shutdown(err *amqp.Error) {
+	ch.Lock()
+	if ch.closed {
+		ch.Unlock()
+		return
+	}
+	ch.closed = true
+	for tag, c := range ch.consumers {
+		close(c.stop)
+		delete(ch.consumers, tag)
+	}
+	var tags []uint64
+	for tag := range ch.unsettled {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+	for _, tag := range tags {
+		d := ch.unsettled[tag]
+		d.Redelivered = true
+		ch.conn.broker.publish(d.queue, d.Delivery)
+	}
+	ch.unsettled = map[uint64]fakeDelivery{}
+	closes, cancels, confirms, returns := ch.closes, ch.cancels, ch.confirms, ch.returns
+	ch.Unlock()
+	for _, c := range closes {
+		if err != nil {
+			c <- err
+		}
+		close(c)
+	}
+	for _, c := range cancels {
+		close(c)
+	}
+	for _, c := range confirms {
+		close(c)
+	}
+	for _, c := range returns {
+		close(c)
+	}
+}
+
+func (ch *fakeChannel) settle(tag uint64, multiple bool) ([]fakeDelivery, error) {
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		return nil, amqp.ErrClosed
+	}
+	var settled []fakeDelivery
+	if multiple {
+		var tags []uint64
+		for t := range ch.unsettled {
+			if t <= tag {
+				tags = append(tags, t)
+			}
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+		for _, t := range tags {
+			settled = append(settled, ch.unsettled[t])
+			delete(ch.unsettled, t)
+		}
+	} else if d, ok := ch.unsettled[tag]; ok {
+		settled = append(settled, d)
+		delete(ch.unsettled, tag)
+	}
+	if len(settled) == 0 {
+		return nil, fmt.Errorf("PRECONDITION_FAILED - unknown delivery tag %d", tag)
+	}
+	return settled, nil
+}
+
+func (ch *fakeChannel) Ack(tag uint64, multiple bool) error {
+	settled, err := ch.settle(tag, multiple)
+	for _, d := range settled {
+		ch.conn.broker.record("ack", d.Delivery)
+	}
+	return err
+}
+
+func (ch *fakeChannel) Nack(tag uint64, multiple bool, requeue bool) error {
+	settled, err := ch.settle(tag, multiple)
+	for _, d := range settled {
+		if requeue {
+			ch.conn.broker.record("requeue", d.Delivery)
+			d.Redelivered = true
+			ch.conn.broker.publish(d.queue, d.Delivery)
+		} else {
+			ch.conn.broker.record("reject", d.Delivery)
+			ch.conn.broker.deadLetter(d.queue, d.Delivery)
+		}
+	}
+	return err
+}
+
+func (ch *fakeChannel) Reject(tag uint64, requeue bool) error { return ch.Nack(tag, false, requeue) }
+
+func (ch *fakeChannel) Publish(exchange, key string, mandatory, immediate bool, msg amqp.Publishing) error {
+	fb := ch.conn.broker
+	if fb.next(&fb.broken) {
+		ch.shutdown(&amqp.Error{Code: amqp.ChannelError, Reason: "CHANNEL_ERROR"})
+		return amqp.ErrClosed
+	}
+	ch.Lock()
+	if ch.closed {
+		ch.Unlock()
+		return amqp.ErrClosed
+	}
+	ch.published++
+	confirmation := amqp.Confirmation{DeliveryTag: ch.published, Ack: true}
+	confirms, returns, confirm := ch.confirms, ch.returns, ch.confirm
+	ch.Unlock()
+	if !confirm || fb.next(&fb.muted) {
+		confirms = nil
+	} else {
+		confirmation.Ack = !fb.next(&fb.nacked)
+	}
+	if mandatory && !fb.routable(key) {
+		for _, c := range returns {
+			c <- amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", Exchange: exchange, RoutingKey: key,
+				MessageId: msg.MessageId, Body: msg.Body}
+		}
+	} else {
+		fb.publish(key, amqp.Delivery{Exchange: exchange, RoutingKey: key, Headers: msg.Headers,
+			ContentType: msg.ContentType, ContentEncoding: msg.ContentEncoding, DeliveryMode: msg.DeliveryMode,
+			Priority: msg.Priority, CorrelationId: msg.CorrelationId, ReplyTo: msg.ReplyTo,
+			Expiration: msg.Expiration, MessageId: msg.MessageId, Timestamp: msg.Timestamp, Type: msg.Type,
+			AppId: msg.AppId, Body: msg.Body})
+	}
+	for _, c := range confirms {
+		c <- confirmation
+	}
+	return nil
+}
+
+func (ch *fakeChannel) open() error {
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		return amqp.ErrClosed
+	}
+	return nil
+}
+
+func (ch *fakeChannel) Qos(prefetchCount, prefetchSize int, global bool) error { return ch.open() }
+func (ch *fakeChannel) Confirm(noWait bool) error {
+	ch.Lock()
+	defer ch.Unlock()
+	ch.confirm = true
+	return nil
+}
+func (ch *fakeChannel) NotifyPublish(c chan amqp.Confirmation) chan amqp.Confirmation {
+	ch.Lock()
+	defer ch.Unlock()
+	ch.confirms = append(ch.confirms, c)
+	return c
+}
+func (ch *fakeChannel) NotifyReturn(c chan amqp.Return) chan amqp.Return {
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		close(c)
+	} else {
+		ch.returns = append(ch.returns, c)
+	}
+	return c
+}
+func (ch *fakeChannel) NotifyClose(c chan *amqp.Error) chan *amqp.Error {
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		close(c)
+	} else {
+		ch.closes = append(ch.closes, c)
+	}
+	return c
+}
+func (ch *fakeChannel) NotifyCancel(c chan string) chan string {
+	ch.Lock()
+	defer ch.Unlock()
+	if ch.closed {
+		close(c)
+	} else {
+		ch.cancels = append(ch.cancels, c)
+	}
+	return c
+}
+func (ch *fakeChannel) ExchangeDeclare(name, kind string, durable, autoDelete, internal, noWait bool,
+	args amqp.Table) error {
+	return ch.open()
+}
+func (ch *fakeChannel) QueueDeclare(name string, durable, autoDelete, exclusive, noWait bool,
+	args amqp.Table) (amqp.Queue, error) {
+	ch.conn.broker.queue(name)
+	return amqp.Queue{Name: name}, ch.open()
+}
+func (ch *fakeChannel) QueueBind(name, key, exchange string, noWait bool, args amqp.Table) error {
+	return ch.open()
+}
+func (ch *fakeChannel) Close() error {
+	ch.shutdown(nil)
+	return nil
+}
+
+// wait for cond up to a second
+func eventually(t *testing.T, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
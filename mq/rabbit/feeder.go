@@ -0,0 +1,199 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/samwooo/bolsa/job/feeder"
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/samwooo/bolsa/logging"
+	"github.com/streadway/amqp"
+	"golang.org/x/net/context"
+)
+
+////////////////////////////////////////////////////////////////////////
+// RabbitMQ Feeder IMP, a message is acked once digested successfully, //
+// and nacked without requeue ( dead lettered ) when it finally fails, //
+// once it exits the channel stays open until every message forwarded //
+// is digested, or the connection's gone                              //
+type FeederImp struct {
+	sync.Mutex
+	logger        logging.Logger
+	conn          *Connection
+	qName         string
+	prefetchCount int
+	prefetchSize  int
+	deliveries    chan amqp.Delivery
+	stop          chan struct{}
+	exit          sync.Once
+	pending       sync.Map
+	inflight      int // forwarded, not digested yet
+}
+
+func (f *FeederImp) forwarded(n int) {
+	f.Lock()
+	f.inflight += n
+	f.Unlock()
+}
+
+// until every message forwarded is digested, or qChan closed
+func (f *FeederImp) settle(closed chan *amqp.Error) {
+	for {
+		f.Lock()
+		inflight := f.inflight
+		f.Unlock()
+		if inflight <= 0 {
+			return
+		}
+		select {
+		case <-closed:
+			return
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+func (f *FeederImp) forward(qChan qChannel) {
+	tag := fmt.Sprintf("%s.feeder.%s", f.qName, correlationId())
+	closed := qChan.NotifyClose(make(chan *amqp.Error, 1))
+	msgCh, err := qChan.Consume(f.qName,
+		tag,
+		false,
+		false,
+		false,
+		false, nil)
+	if err != nil {
+		f.logger.Errorf("consume failed ( %s )", err.Error())
+		qChan.Close()
+		return
+	}
+	for stopped := false; !stopped; {
+		select {
+		case m, more := <-msgCh:
+			if !more {
+				qChan.Close()
+				return
+			}
+			select {
+			case f.deliveries <- m:
+				f.forwarded(1)
+			case <-f.stop:
+				// m and whatever's prefetched go back to the queue once qChan closes
+				stopped = true
+			}
+		case <-f.stop:
+			stopped = true
+		}
+	}
+	if err := qChan.Cancel(tag, false); err != nil {
+		f.logger.Warnf("cancel ( %s ) failed ( %s )", tag, err.Error())
+	}
+	f.settle(closed)
+	qChan.Close()
+}
+
+func (f *FeederImp) stopped() bool {
+	select {
+	case <-f.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func (f *FeederImp) run(conn *Connection) {
+	connected, reconnecting, closed := conn.register(f)
+	go func(connected, reconnecting, closed chan struct{}) {
+		for {
+			select {
+			case <-closed:
+				f.logger.Info("feeder terminated")
+				return
+			case <-reconnecting:
+				f.logger.Info("feeder wait for reconnecting")
+				time.Sleep(50 * time.Millisecond)
+			case <-connected:
+				if f.stopped() {
+					continue
+				} else if qChan, err := conn.channel(f.prefetchCount, f.prefetchSize); err != nil {
+					f.logger.Error(err)
+				} else {
+					f.logger.Info("feeder channel refreshed")
+					f.forward(qChan)
+				}
+			default:
+				time.Sleep(50 * time.Millisecond)
+			}
+		}
+	}(connected, reconnecting, closed)
+}
+
+func (f *FeederImp) delivery(d model.Done) (amqp.Delivery, bool) {
+	if v, ok := f.pending.Load(d.Key); ok {
+		m, ok := v.(amqp.Delivery)
+		return m, ok
+	} else {
+		return amqp.Delivery{}, false
+	}
+}
+
+func (f *FeederImp) Name() string     { return "rabbit" }
+func (f *FeederImp) Continuous() bool { return true }
+func (f *FeederImp) DoInit(ch chan model.Done) error {
+	f.run(f.conn)
+	f.conn.start()
+	return nil
+}
+func (f *FeederImp) DoExit(ch chan model.Done) error {
+	f.exit.Do(func() { close(f.stop) })
+	return nil
+}
+func (f *FeederImp) DoWork(ch chan model.Done) error {
+	select {
+	case m := <-f.deliveries:
+		d := model.NewDone(nil, m.Body, nil, 0, m.Body, model.KeyFrom(m.Body))
+		f.pending.Store(d.Key, m)
+		ch <- d
+		return nil
+	case <-time.After(50 * time.Millisecond):
+		return nil
+	}
+}
+func (f *FeederImp) DoRetry(ch chan model.Done, d model.Done) error {
+	ch <- d
+	return nil
+}
+func (f *FeederImp) DoPush(ch chan model.Done, data interface{}) error {
+	ch <- model.NewDone(nil, data, nil, 0, data, model.KeyFrom(data))
+	return nil
+}
+func (f *FeederImp) DoDigest(d model.Done) error {
+	if m, ok := f.delivery(d); !ok {
+		return nil
+	} else {
+		f.pending.Delete(d.Key)
+		defer f.forwarded(-1)
+		if d.E == nil || model.IsDuplicate(d.E) {
+			if err := m.Ack(false); err != nil {
+				return fmt.Errorf("ack ( %s ) failed ( %s )", string(m.Body), err.Error())
+			}
+		} else {
+			if err := m.Nack(false, false); err != nil {
+				return fmt.Errorf("nack ( %s ) failed ( %s )", string(m.Body), err.Error())
+			}
+		}
+		return nil
+	}
+}
+
+func NewFeederImp(conn *Connection, qName string, prefetchCount, prefetchSize int) *FeederImp {
+	return &FeederImp{logger: logging.GetLogger(" ⓠ " + qName + " "), conn: conn, qName: qName,
+		prefetchCount: prefetchCount, prefetchSize: prefetchSize,
+		deliveries: make(chan amqp.Delivery), stop: make(chan struct{})}
+}
+
+func NewFeeder(ctx context.Context, name string, workers int, conn *Connection, qName string,
+	prefetchCount, prefetchSize int) *feeder.Feeder {
+	return feeder.NewFeeder(ctx, name, workers, NewFeederImp(conn, qName, prefetchCount, prefetchSize))
+}
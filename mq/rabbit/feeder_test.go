@@ -0,0 +1,64 @@
+package rabbit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+// DoWork until n dones are out
+func feed(t *testing.T, f *FeederImp, ch chan model.Done, n int) []model.Done {
+	var dones []model.Done
+	for start := time.Now(); len(dones) < n; {
+		assert.Nil(t, f.DoWork(ch))
+		for len(ch) > 0 {
+			dones = append(dones, <-ch)
+		}
+		if time.Since(start) > time.Second {
+			t.Fatal("nothing forwarded in time")
+		}
+	}
+	return dones
+}
+
+func TestFeederDigest(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	f := NewFeederImp(newFakeConnection(ctx, fb), "Q", 10, 0)
+	ch := make(chan model.Done, 10)
+	assert.Nil(t, f.DoInit(ch))
+	fb.push("Q", nil, "Lucy")
+	fb.push("Q", nil, "Lily")
+	dones := feed(t, f, ch, 2)
+	assert.Nil(t, f.DoDigest(dones[0]))
+	dones[1].E = errors.New("oops")
+	assert.Nil(t, f.DoDigest(dones[1]))
+	assert.Equal(t, []string{"ack Lucy", "reject Lily"}, fb.records())
+	// pushed into the feeder, nothing to settle
+	assert.Nil(t, f.DoDigest(model.NewDone(nil, "Sam", nil, 0, "Sam", "Sam")))
+}
+
+func TestFeederSettlesAfterExit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	f := NewFeederImp(newFakeConnection(ctx, fb), "Q", 10, 0)
+	ch := make(chan model.Done, 10)
+	assert.Nil(t, f.DoInit(ch))
+	fb.push("Q", nil, "Lucy")
+	dones := feed(t, f, ch, 1)
+	assert.Nil(t, f.DoExit(ch))
+	// the channel's kept open for what's still in the pipeline
+	time.Sleep(100 * time.Millisecond)
+	assert.Nil(t, f.DoDigest(dones[0]))
+	assert.Equal(t, []string{"ack Lucy"}, fb.records())
+	// nothing's consumed once exited
+	fb.push("Q", nil, "Lily")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 1, len(fb.queue("Q")))
+}
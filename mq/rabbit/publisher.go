@@ -0,0 +1,379 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/streadway/amqp"
+	"golang.org/x/net/context"
+)
+
+//////////////
+// Message //
+type Message struct {
+	Exchange   string
+	RoutingKey string
+	Mandatory  bool          // returned if unroutable
+	Timeout    time.Duration // confirm timeout, the publisher's by default
+	amqp.Publishing
+}
+
+func (m *Message) SetHeader(key string, value interface{}) *Message {
+	if m.Headers == nil {
+		m.Headers = amqp.Table{}
+	}
+	m.Headers[key] = value
+	return m
+}
+func (m *Message) SetContentType(contentType string) *Message {
+	m.ContentType = contentType
+	return m
+}
+func (m *Message) SetPersistent(persistent bool) *Message {
+	if persistent {
+		m.DeliveryMode = amqp.Persistent
+	} else {
+		m.DeliveryMode = amqp.Transient
+	}
+	return m
+}
+func (m *Message) SetPriority(priority uint8) *Message {
+	m.Priority = priority
+	return m
+}
+
+func (m *Message) SetMandatory(mandatory bool) *Message {
+	m.Mandatory = mandatory
+	return m
+}
+func (m *Message) SetTimeout(timeout time.Duration) *Message {
+	m.Timeout = timeout
+	return m
+}
+
+// any other property, e.g. MessageId, CorrelationId, Expiration
+func (m *Message) SetProperties(properties amqp.Publishing) *Message {
+	body := m.Body
+	m.Publishing = properties
+	m.Body = body
+	return m
+}
+
+func NewMessage(exchange, routingKey string, body []byte) *Message {
+	return &Message{exchange, routingKey, false, 0, amqp.Publishing{ContentType: "text/plain", Body: body}}
+}
+
+//////////////////////////////////////////
+// Unroutable mandatory message returned //
+type ReturnError struct{ amqp.Return }
+
+func (re ReturnError) Error() string {
+	return fmt.Sprintf("( %s, %s ) returned ( %d, %s )", re.Exchange, re.RoutingKey, re.ReplyCode, re.ReplyText)
+}
+
+type pooledChannel struct {
+	qChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+//////////////////////////////////////////////////////////////////////////
+// Publisher, reuses a Connection with a pool of channels, it buffers //
+// messages while disconnected and flushes them once reconnected     //
+type Publisher struct {
+	sync.Mutex
+	logger    logging.Logger
+	conn      *Connection
+	size      int
+	open      int // pooled channels, idle or checked out
+	confirm   bool
+	timeout   time.Duration
+	channels  chan *pooledChannel
+	buffer    chan *Message
+	held      int32 // buffered or being flushed
+	connected atomic.Value
+	onReturn  atomic.Value
+	closed    chan struct{}
+}
+
+func (p *Publisher) isConnected() bool {
+	connected, _ := p.connected.Load().(bool)
+	return connected
+}
+
+func (p *Publisher) pooled(qChan qChannel) (*pooledChannel, error) {
+	pc := &pooledChannel{qChannel: qChan}
+	if p.confirm {
+		if err := qChan.Confirm(false); err != nil {
+			qChan.Close()
+			return nil, err
+		}
+		pc.confirms = qChan.NotifyPublish(make(chan amqp.Confirmation, 1))
+		pc.returns = qChan.NotifyReturn(make(chan amqp.Return, 1))
+	} else {
+		pc.returns = qChan.NotifyReturn(make(chan amqp.Return, 1))
+		go func() {
+			for r := range pc.returns {
+				p.returned(r)
+			}
+		}()
+	}
+	return pc, nil
+}
+
+func (p *Publisher) returned(r amqp.Return) {
+	if onReturn, ok := p.onReturn.Load().(func(amqp.Return)); ok && onReturn != nil {
+		onReturn(r)
+	} else {
+		p.logger.Warnf("( %s ) unhandled return", ReturnError{r}.Error())
+	}
+}
+
+// tops the pool up to size, a checked out channel counts as well as an idle one
+func (p *Publisher) fill() {
+	p.Lock()
+	defer p.Unlock()
+	for ; p.open < p.size; p.open++ {
+		if qChan, err := p.conn.channel(0, 0); err != nil {
+			p.logger.Error(err)
+			return
+		} else if pc, err := p.pooled(qChan); err != nil {
+			p.logger.Errorf("confirm mode failed ( %s )", err.Error())
+			return
+		} else {
+			p.channels <- pc
+		}
+	}
+}
+
+// pc leaves the pool for good, fill replaces it
+func (p *Publisher) discard(pc *pooledChannel) {
+	pc.Close()
+	p.Lock()
+	p.open--
+	p.Unlock()
+}
+
+func (p *Publisher) empty() {
+	for {
+		select {
+		case pc := <-p.channels:
+			p.discard(pc)
+		default:
+			return
+		}
+	}
+}
+
+func (p *Publisher) run(conn *Connection) {
+	connected, reconnecting, closed := conn.register(p)
+	go func(connected, reconnecting, closed chan struct{}) {
+		for {
+			select {
+			case <-closed:
+				p.logger.Info("publisher terminated")
+				p.connected.Store(false)
+				p.empty()
+				close(p.closed)
+				return
+			case <-reconnecting:
+				p.logger.Info("publisher wait for reconnecting")
+				p.connected.Store(false)
+				p.empty()
+			case <-connected:
+				p.empty()
+				p.fill()
+				p.connected.Store(true)
+				p.logger.Info("publisher channels refreshed")
+			}
+		}
+	}(connected, reconnecting, closed)
+}
+
+func (p *Publisher) flush() {
+	for {
+		select {
+		case <-p.closed:
+			return
+		case m := <-p.buffer:
+			if !p.flushOne(m) {
+				return
+			}
+		}
+	}
+}
+
+// false once closed, m is held until published, held again or dropped
+func (p *Publisher) flushOne(m *Message) bool {
+	defer atomic.AddInt32(&p.held, -1)
+	for !p.isConnected() {
+		select {
+		case <-p.closed:
+			p.logger.Warnf("drop buffered message ( %s )", string(m.Body))
+			return false
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if err := p.publish(m); err != nil {
+		p.logger.Errorf("publish buffered ( %s ) failed ( %s )", string(m.Body), err.Error())
+		if _, returned := err.(ReturnError); returned {
+			return true
+		}
+		p.hold(m)
+		time.Sleep(50 * time.Millisecond)
+	}
+	return true
+}
+
+// counted held ahead of buffering, the flusher may take it before hold returns
+func (p *Publisher) hold(m *Message) error {
+	atomic.AddInt32(&p.held, 1)
+	select {
+	case <-p.closed:
+		atomic.AddInt32(&p.held, -1)
+		return fmt.Errorf("publisher closed, ( %s ) dropped", string(m.Body))
+	case p.buffer <- m:
+		p.logger.Debugf("buffer ( %s )", string(m.Body))
+		return nil
+	default:
+		atomic.AddInt32(&p.held, -1)
+		return fmt.Errorf("buffer full, ( %s ) dropped", string(m.Body))
+	}
+}
+
+// wait for the broker, a return always comes ahead of its ack
+func (p *Publisher) confirmed(pc *pooledChannel, m *Message) error {
+	timeout := m.Timeout
+	if timeout <= 0 {
+		timeout = p.timeout
+	}
+	select {
+	case c, ok := <-pc.confirms:
+		if !ok {
+			return fmt.Errorf("channel closed before confirm")
+		} else if !c.Ack {
+			return fmt.Errorf("( %d ) nacked by broker", c.DeliveryTag)
+		}
+		select {
+		case r := <-pc.returns:
+			return ReturnError{r}
+		default:
+			return nil
+		}
+	case <-time.After(timeout):
+		return fmt.Errorf("confirm timeout ( %+v )", timeout)
+	}
+}
+
+func (p *Publisher) publish(m *Message) error {
+	select {
+	case pc := <-p.channels:
+		err := pc.Publish(m.Exchange, m.RoutingKey, m.Mandatory, false, m.Publishing)
+		if err == nil && p.confirm {
+			err = p.confirmed(pc, m)
+		}
+		if _, returned := err.(ReturnError); err == nil || returned {
+			select {
+			case p.channels <- pc:
+			default:
+				p.discard(pc)
+			}
+		} else {
+			// broken or out of step channel, replace it
+			p.discard(pc)
+			go p.fill()
+		}
+		return err
+	case <-time.After(time.Second):
+		return fmt.Errorf("no channel available")
+	}
+}
+
+// buffered messages, and the one being flushed, get published up to ctx
+func (p *Publisher) drain(ctx context.Context) error {
+	for atomic.LoadInt32(&p.held) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("publisher drain failed, ( %d ) buffered dropped", atomic.LoadInt32(&p.held))
+		case <-p.closed:
+			return nil
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	return nil
+}
+
+// for mandatory messages returned without confirm mode
+func (p *Publisher) NotifyReturn(onReturn func(amqp.Return)) *Publisher {
+	p.onReturn.Store(onReturn)
+	return p
+}
+
+// never buffers, nil only when the broker confirmed it in confirm mode
+func (p *Publisher) PublishSync(m *Message) error {
+	if !p.isConnected() {
+		return fmt.Errorf("publisher disconnected, ( %s ) not published", string(m.Body))
+	} else if err := p.publish(m); err != nil {
+		p.logger.Warnf("publish ( %s ) failed ( %s )", string(m.Body), err.Error())
+		return err
+	} else {
+		p.logger.Debugf("publish ( %s ) succeed", string(m.Body))
+		return nil
+	}
+}
+
+func (p *Publisher) PublishAsync(m *Message) <-chan error {
+	result := make(chan error, 1)
+	go func() {
+		result <- p.PublishSync(m)
+		close(result)
+	}()
+	return result
+}
+
+// publish now if connected, otherwise buffer it until reconnected
+func (p *Publisher) Publish(m *Message) error {
+	if !p.isConnected() {
+		return p.hold(m)
+	} else if err := p.publish(m); err != nil {
+		if _, returned := err.(ReturnError); returned {
+			p.logger.Warnf("publish ( %s ) failed ( %s )", string(m.Body), err.Error())
+			return err
+		}
+		p.logger.Warnf("publish ( %s ) failed ( %s ), buffered", string(m.Body), err.Error())
+		return p.hold(m)
+	} else {
+		p.logger.Debugf("publish ( %s ) succeed", string(m.Body))
+		return nil
+	}
+}
+
+func newPublisher(conn *Connection, channels, buffer int, confirm bool, timeout time.Duration) *Publisher {
+	if channels <= 0 {
+		channels = 1
+	}
+	p := &Publisher{logger: logging.GetLogger(" ⓠ publisher "), conn: conn, size: channels,
+		confirm: confirm, timeout: timeout,
+		channels: make(chan *pooledChannel, channels), buffer: make(chan *Message, buffer),
+		closed: make(chan struct{})}
+	p.connected.Store(false)
+	p.run(conn)
+	go p.flush()
+	conn.start()
+	return p
+}
+
+func NewPublisher(conn *Connection, channels, buffer int) *Publisher {
+	return newPublisher(conn, channels, buffer, false, 0)
+}
+
+// every message waits for the broker's confirm up to timeout, unless it has its own
+func NewConfirmPublisher(conn *Connection, channels, buffer int, timeout time.Duration) *Publisher {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return newPublisher(conn, channels, buffer, true, timeout)
+}
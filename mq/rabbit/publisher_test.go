@@ -0,0 +1,189 @@
+package rabbit
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+var _ = logging.DefaultLogger("", logging.LogLevelFrom("ERROR"), 100)
+
+func TestMessage(t *testing.T) {
+	m := NewMessage("E", "Q", []byte("Lucy")).
+		SetProperties(amqp.Publishing{MessageId: "1"}).
+		SetHeader("a", 1).
+		SetContentType("application/json").
+		SetPersistent(true).
+		SetPriority(5)
+	assert.Equal(t, "E", m.Exchange)
+	assert.Equal(t, "Q", m.RoutingKey)
+	assert.Equal(t, []byte("Lucy"), m.Body)
+	assert.Equal(t, "1", m.MessageId)
+	assert.Equal(t, amqp.Table{"a": 1}, m.Headers)
+	assert.Equal(t, "application/json", m.ContentType)
+	assert.Equal(t, amqp.Persistent, m.DeliveryMode)
+	assert.Equal(t, uint8(5), m.Priority)
+}
+
+func TestPublisherBuffersWhileDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewPublisher(NewConnection(ctx, "guest", "guest", "127.0.0.1:1"), 2, 2)
+	buffered := 0
+	for i := 0; i < 10; i++ {
+		if err := p.Publish(NewMessage("", "Q", []byte("Lily"))); err == nil {
+			buffered++
+		}
+	}
+	// one more might be held by the flusher waiting for a connection
+	assert.Equal(t, true, buffered == 2 || buffered == 3)
+}
+
+func TestConfirmPublisherWhileDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewConfirmPublisher(NewConnection(ctx, "guest", "guest", "127.0.0.1:1"), 1, 1, 0)
+	assert.NotNil(t, p.PublishSync(NewMessage("", "Q", []byte("Lucy")).SetMandatory(true)))
+	assert.NotNil(t, <-p.PublishAsync(NewMessage("", "Q", []byte("Lily"))))
+}
+
+func TestReturnError(t *testing.T) {
+	err := ReturnError{amqp.Return{ReplyCode: 312, ReplyText: "NO_ROUTE", Exchange: "E", RoutingKey: "Q"}}
+	assert.Equal(t, "( E, Q ) returned ( 312, NO_ROUTE )", err.Error())
+}
+
+func TestPublisherReplacesBrokenChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	p := NewPublisher(newFakeConnection(ctx, fb), 2, 0)
+	eventually(t, p.isConnected)
+	fb.Lock()
+	fb.broken = 1
+	fb.Unlock()
+	var wg sync.WaitGroup
+	failed := make(chan error, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if err := p.PublishSync(NewMessage("", "Q", []byte("Lucy"))); err != nil {
+					failed <- err
+				}
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishers stuck")
+	}
+	close(failed)
+	assert.Equal(t, 1, len(failed))
+	assert.Equal(t, 99, len(fb.queue("Q")))
+	eventually(t, func() bool { return len(p.channels) == 2 })
+	p.Lock()
+	assert.Equal(t, 2, p.open)
+	p.Unlock()
+}
+
+func newConfirmPublisher(t *testing.T, ctx context.Context, fb *fakeBroker) *Publisher {
+	p := NewConfirmPublisher(newFakeConnection(ctx, fb), 1, 0, time.Second)
+	eventually(t, p.isConnected)
+	return p
+}
+
+func TestConfirmPublisherAcked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	p := newConfirmPublisher(t, ctx, fb)
+	assert.Nil(t, p.PublishSync(NewMessage("", "Q", []byte("Lucy"))))
+	assert.Equal(t, "Lucy", string((<-fb.queue("Q")).Body))
+}
+
+func TestConfirmPublisherNacked(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.nacked = 1
+	p := newConfirmPublisher(t, ctx, fb)
+	err := p.PublishSync(NewMessage("", "Q", []byte("Lucy")))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "nacked by broker")
+	// the channel out of step is replaced
+	eventually(t, func() bool { return len(p.channels) == 1 })
+	assert.Nil(t, p.PublishSync(NewMessage("", "Q", []byte("Lily"))))
+}
+
+func TestConfirmPublisherTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.muted = 1
+	p := newConfirmPublisher(t, ctx, fb)
+	err := p.PublishSync(NewMessage("", "Q", []byte("Lucy")).SetTimeout(50 * time.Millisecond))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "confirm timeout")
+	eventually(t, func() bool { return len(p.channels) == 1 })
+	assert.Nil(t, p.PublishSync(NewMessage("", "Q", []byte("Lily"))))
+}
+
+func TestConfirmPublisherReturned(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	p := newConfirmPublisher(t, ctx, fb)
+	err := p.PublishSync(NewMessage("", "nowhere", []byte("Lucy")).SetMandatory(true))
+	re, returned := err.(ReturnError)
+	assert.True(t, returned)
+	assert.Equal(t, "nowhere", re.RoutingKey)
+	assert.Equal(t, "Lucy", string(re.Body))
+	// returned messages aren't buffered, the channel stays pooled
+	_, returned = p.Publish(NewMessage("", "nowhere", []byte("Lily")).SetMandatory(true)).(ReturnError)
+	assert.True(t, returned)
+	assert.Equal(t, 0, len(p.buffer))
+	assert.Equal(t, 1, len(p.channels))
+}
+
+func TestPublisherNotifyReturn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	returned := make(chan amqp.Return, 1)
+	p := NewPublisher(newFakeConnection(ctx, fb), 1, 0).NotifyReturn(func(r amqp.Return) { returned <- r })
+	eventually(t, p.isConnected)
+	assert.Nil(t, p.PublishSync(NewMessage("", "nowhere", []byte("Lucy")).SetMandatory(true)))
+	r := <-returned
+	assert.Equal(t, uint16(312), r.ReplyCode)
+	assert.Equal(t, "Lucy", string(r.Body))
+}
+
+func TestPublisherDrainWaitsForFlushing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	p := NewConfirmPublisher(newFakeConnection(ctx, fb), 1, 10, 200*time.Millisecond)
+	eventually(t, p.isConnected)
+	// unconfirmed by Publish, then by the flusher while nothing's left in the buffer
+	fb.Lock()
+	fb.muted = 2
+	fb.Unlock()
+	assert.Nil(t, p.Publish(NewMessage("", "Q", []byte("Lucy"))))
+	drained, done := context.WithTimeout(ctx, 2*time.Second)
+	defer done()
+	assert.Nil(t, p.drain(drained))
+	// the unconfirmed ones got there as well
+	assert.Equal(t, 3, len(fb.queue("Q")))
+}
@@ -1,20 +1,25 @@
 package rabbit
 
 import (
+	"time"
+
 	"github.com/streadway/amqp"
 	"golang.org/x/net/context"
 )
 
+// one off, prefer a Publisher to publish more than once
 func Publish(ctx context.Context, qUser, qPassword, qUri, exchange, topic string, body []byte) error {
-	qConn := NewConnection(ctx, qUser, qPassword, qUri)
-	if _, err := qConn.connect(); err != nil {
-		qConn.logger.Error(err)
+	conn := NewConnection(ctx, qUser, qPassword, qUri)
+	if qConn, err := conn.connect(3); err != nil {
+		conn.logger.Error(err)
 		return err
 	} else {
-		if qChan, err := qConn.channel(0, 0); err != nil {
-			qConn.logger.Errorf("( %s ) channel failed ( %s )", qUri, err.Error())
+		defer qConn.Close()
+		if qChan, err := qConn.Channel(); err != nil {
+			conn.logger.Errorf("( %s ) channel failed ( %s )", qUri, err.Error())
 			return err
 		} else {
+			defer qChan.Close()
 			if err := qChan.Publish(
 				exchange,
 				topic,
@@ -24,10 +29,10 @@ func Publish(ctx context.Context, qUser, qPassword, qUri, exchange, topic string
 					ContentType: "text/plain",
 					Body:        body,
 				}); err != nil {
-				qConn.logger.Errorf("publish ( %s ) failed ( %s )", string(body), err.Error())
+				conn.logger.Errorf("publish ( %s ) failed ( %s )", string(body), err.Error())
 				return err
 			} else {
-				qConn.logger.Debugf("publish ( %s )  succeed", string(body))
+				conn.logger.Debugf("publish ( %s )  succeed", string(body))
 				return nil
 			}
 		}
@@ -54,3 +59,74 @@ func RunConsumerUpon(conn *Connection, qName string, prefetchCount, prefetchSize
 	}
 	conn.start()
 }
+
+// N Consumers per Queue reusing Connection, each declares topology ahead of consuming
+func RunConsumerWithTopology(conn *Connection, topology *Topology, qName string, prefetchCount, prefetchSize,
+	maxRetries, workers int, handler MessageHandler) {
+	for id := 0; id < workers; id++ {
+		c := newRetryableConsumer(id, qName, prefetchCount, prefetchSize, handler, maxRetries)
+		c.topology = topology
+		c.run(conn)
+	}
+	conn.start()
+}
+
+// N Consumers per Queue reusing Connection, handler sees the Delivery and decides how it's settled,
+// topology is optional
+func RunHandlerUpon(conn *Connection, topology *Topology, qName string, prefetchCount, prefetchSize,
+	maxRetries, workers int, handler Handler) {
+	for id := 0; id < workers; id++ {
+		c := newRetryableConsumer(id, qName, prefetchCount, prefetchSize, handler, maxRetries)
+		c.topology = topology
+		c.run(conn)
+	}
+	conn.start()
+}
+
+////////////////////////////////////////////////////////////////////////
+// ConsumerOptions, keep PrefetchCount at least Parallel * Batch, or //
+// batches hardly fill up and parallel workers sit idle            //
+type ConsumerOptions struct {
+	PrefetchCount int
+	PrefetchSize  int
+	Workers       int           // consumers, each upon its own channel
+	Parallel      int           // deliveries ( or batches ) handled at once per consumer
+	Batch         int           // deliveries per BatchHandler call
+	Linger        time.Duration // longest wait for a batch to fill up, 100ms by default
+	MaxRetries    int
+	Topology      *Topology    // declared ahead of consuming if any
+	Retry         *RetryPolicy // delayed retries instead of MaxRetries, not for batches
+}
+
+func RunHandlerWith(conn *Connection, qName string, opts ConsumerOptions, handler Handler) {
+	topology := opts.Topology
+	if opts.Retry != nil {
+		topology = opts.Retry.Topology(qName)
+		if opts.Topology != nil {
+			topology = (&Topology{}).Merge(opts.Topology, topology)
+		}
+	}
+	for id := 0; id < opts.Workers; id++ {
+		var c *retryableConsumer
+		if opts.Retry != nil {
+			c = newScheduledConsumer(conn, id, qName, opts.PrefetchCount, opts.PrefetchSize, handler, opts.Retry)
+		} else {
+			c = newRetryableConsumer(id, qName, opts.PrefetchCount, opts.PrefetchSize, handler, opts.MaxRetries)
+		}
+		c.topology = topology
+		c.parallel = opts.Parallel
+		c.run(conn)
+	}
+	conn.start()
+}
+
+func RunBatchHandlerWith(conn *Connection, qName string, opts ConsumerOptions, handler BatchHandler) {
+	for id := 0; id < opts.Workers; id++ {
+		c := newBatchConsumer(id, qName, opts.PrefetchCount, opts.PrefetchSize, opts.Batch, opts.Linger,
+			handler, opts.MaxRetries)
+		c.topology = opts.Topology
+		c.parallel = opts.Parallel
+		c.run(conn)
+	}
+	conn.start()
+}
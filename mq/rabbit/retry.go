@@ -0,0 +1,201 @@
+package rabbit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+)
+
+// times a RetryPolicy has scheduled the message, x-death counts every tier apart
+const retriesHeader = "x-retries"
+
+/////////////////////////////////////////////////////////////////////////////
+// RetryPolicy, a rejected delivery is republished to wait out the delay  //
+// of its attempt, then it's back to the queue, once it's failed Limit    //
+// retries it's parked, or dropped if there's no Parking queue, it's     //
+// acked only once the broker confirmed the republish                    //
+// 1: TTL queues queue.retry.<delay>, one per delay, dead letter back to //
+// queue through the default exchange                                    //
+// 2: or, with the delayed message plugin, Delayed exchange bound to     //
+// queue by its name, the delay goes in x-delay                          //
+type RetryPolicy struct {
+	Delays  []time.Duration // per attempt, the last one for every attempt after, e.g. 1s, 10s, 1m, 10m
+	Limit   int             // retries before parking, len(Delays) by default
+	Parking string          // queue parked messages end up in, dropped if empty
+	Delayed string          // x-delayed-message exchange instead of TTL queues if any
+	Timeout time.Duration   // of the broker's confirm, 5s by default
+}
+
+func (p *RetryPolicy) limit() int {
+	if p.Limit > 0 {
+		return p.Limit
+	}
+	return len(p.Delays)
+}
+
+// of the attempt after retries
+func (p *RetryPolicy) delay(retries int) time.Duration {
+	if len(p.Delays) == 0 {
+		return 0
+	} else if retries >= len(p.Delays) {
+		return p.Delays[len(p.Delays)-1]
+	}
+	return p.Delays[retries]
+}
+
+func tierOf(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+// what queue needs for p, declare it along with queue itself
+func (p *RetryPolicy) Topology(queue string) *Topology {
+	t := &Topology{}
+	if p.Delayed != "" {
+		t.Exchanges = append(t.Exchanges, Exchange{Name: p.Delayed, Kind: "x-delayed-message", Durable: true,
+			Args: amqp.Table{"x-delayed-type": amqp.ExchangeDirect}})
+		t.Bindings = append(t.Bindings, Binding{Queue: queue, Exchange: p.Delayed, Key: queue})
+	} else {
+		declared := map[time.Duration]bool{}
+		for _, delay := range p.Delays {
+			if !declared[delay] {
+				declared[delay] = true
+				t.Queues = append(t.Queues, Queue{Name: tierOf(queue, delay), Durable: true, Args: amqp.Table{
+					"x-message-ttl":             int64(delay / time.Millisecond),
+					"x-dead-letter-exchange":    "",
+					"x-dead-letter-routing-key": queue}})
+			}
+		}
+	}
+	if p.Parking != "" {
+		t.Queues = append(t.Queues, Queue{Name: p.Parking, Durable: true})
+	}
+	return t
+}
+
+// d as it was published, retries counted in its headers
+func republishing(d *Delivery, retries int) amqp.Publishing {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[retriesHeader] = int64(retries)
+	return amqp.Publishing{
+		Headers:         headers,
+		ContentType:     d.ContentType,
+		ContentEncoding: d.ContentEncoding,
+		DeliveryMode:    amqp.Persistent,
+		Priority:        d.Priority,
+		Type:            d.Type,
+		AppId:           d.AppId,
+		Expiration:      d.Expiration,
+		CorrelationId:   d.CorrelationId,
+		ReplyTo:         d.ReplyTo,
+		MessageId:       d.MessageId,
+		Timestamp:       d.Timestamp,
+		Body:            d.Body,
+	}
+}
+
+////////////////////////////////////////////////////////////////////
+// republisher, a confirm mode channel apart from the consuming one, //
+// republishes go one at a time, each waits for the broker's confirm //
+// mandatory ones returned unroutable fail even though acked         //
+type republisher struct {
+	sync.Mutex
+	conn     *Connection
+	timeout  time.Duration
+	qChan    qChannel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+}
+
+func (r *republisher) reset() {
+	if r.qChan != nil {
+		r.qChan.Close()
+		r.qChan, r.confirms, r.returns = nil, nil, nil
+	}
+}
+
+func (r *republisher) open() error {
+	if r.qChan != nil {
+		return nil
+	} else if qChan, err := r.conn.channel(0, 0); err != nil {
+		return err
+	} else if err := qChan.Confirm(false); err != nil {
+		qChan.Close()
+		return fmt.Errorf("confirm mode failed ( %s )", err.Error())
+	} else {
+		r.qChan, r.confirms = qChan, qChan.NotifyPublish(make(chan amqp.Confirmation, 1))
+		r.returns = qChan.NotifyReturn(make(chan amqp.Return, 1))
+		return nil
+	}
+}
+
+// nil only once the broker acked it and, if mandatory, routed it, a channel gone stale since is reopened once
+func (r *republisher) publish(exchange, key string, mandatory bool, m amqp.Publishing) (err error) {
+	r.Lock()
+	defer r.Unlock()
+	for retry := 0; retry < 2; retry++ {
+		if err = r.open(); err != nil {
+			return
+		} else if err = r.qChan.Publish(exchange, key, mandatory, false, m); err != nil {
+			r.reset()
+			continue
+		}
+		select {
+		case c, ok := <-r.confirms:
+			if !ok {
+				r.reset()
+				return fmt.Errorf("channel closed before confirm")
+			} else if !c.Ack {
+				return fmt.Errorf("( %d ) nacked by broker", c.DeliveryTag)
+			}
+			// a return always comes ahead of its ack
+			select {
+			case ret := <-r.returns:
+				return ReturnError{ret}
+			default:
+				return nil
+			}
+		case <-time.After(r.timeout):
+			// a late confirm would be taken for the next one's
+			r.reset()
+			return fmt.Errorf("confirm timeout ( %+v )", r.timeout)
+		}
+	}
+	return
+}
+
+func newRepublisher(conn *Connection, timeout time.Duration) *republisher {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	return &republisher{conn: conn, timeout: timeout}
+}
+
+// d waits out the delay of its next attempt, of queue, a tier queue gone missing fails it,
+// the delayed message plugin returns every mandatory message though, it's routed later
+func (p *RetryPolicy) schedule(r *republisher, queue string, d *Delivery) error {
+	delay, m := p.delay(d.Retries), republishing(d, d.Retries+1)
+	exchange, key, mandatory := "", tierOf(queue, delay), true
+	if p.Delayed != "" {
+		exchange, key, mandatory = p.Delayed, queue, false
+		m.Headers["x-delay"] = int64(delay / time.Millisecond)
+	}
+	if err := r.publish(exchange, key, mandatory, m); err != nil {
+		return fmt.Errorf("schedule ( %s ) in ( %s ) failed ( %s )", string(d.Body), delay, err.Error())
+	}
+	return nil
+}
+
+// false if there's no Parking queue, d is dropped then
+func (p *RetryPolicy) park(r *republisher, d *Delivery) (bool, error) {
+	if p.Parking == "" {
+		return false, nil
+	} else if err := r.publish("", p.Parking, true, republishing(d, d.Retries)); err != nil {
+		return false, fmt.Errorf("park ( %s ) failed ( %s )", string(d.Body), err.Error())
+	}
+	return true, nil
+}
@@ -0,0 +1,131 @@
+package rabbit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestRetryPolicyTopology(t *testing.T) {
+	policy := &RetryPolicy{Delays: []time.Duration{time.Second, time.Minute, time.Minute}, Parking: "Q.parked"}
+	topology := policy.Topology("Q")
+	assert.Equal(t, 3, len(topology.Queues))
+	assert.Equal(t, "Q.retry.1s", topology.Queues[0].Name)
+	assert.Equal(t, int64(1000), topology.Queues[0].Args["x-message-ttl"])
+	assert.Equal(t, "", topology.Queues[0].Args["x-dead-letter-exchange"])
+	assert.Equal(t, "Q", topology.Queues[0].Args["x-dead-letter-routing-key"])
+	assert.Equal(t, "Q.retry.1m0s", topology.Queues[1].Name)
+	assert.Equal(t, "Q.parked", topology.Queues[2].Name)
+	assert.Equal(t, 3, policy.limit())
+
+	policy = &RetryPolicy{Delays: []time.Duration{time.Second}, Delayed: "E.delayed"}
+	topology = policy.Topology("Q")
+	assert.Equal(t, 0, len(topology.Queues))
+	assert.Equal(t, []Exchange{{Name: "E.delayed", Kind: "x-delayed-message", Durable: true,
+		Args: amqp.Table{"x-delayed-type": amqp.ExchangeDirect}}}, topology.Exchanges)
+	assert.Equal(t, []Binding{{Queue: "Q", Exchange: "E.delayed", Key: "Q"}}, topology.Bindings)
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	policy := &RetryPolicy{Delays: []time.Duration{time.Second, 10 * time.Second}, Limit: 5}
+	assert.Equal(t, time.Second, policy.delay(0))
+	assert.Equal(t, 10*time.Second, policy.delay(1))
+	assert.Equal(t, 10*time.Second, policy.delay(4))
+	assert.Equal(t, 5, policy.limit())
+}
+
+func TestRetriesFromHeader(t *testing.T) {
+	assert.Equal(t, 2, retriesFrom(amqp.Table{retriesHeader: int64(2), "x-death": retried(1)["x-death"]}))
+	assert.Equal(t, 3, retriesFrom(amqp.Table{retriesHeader: int32(3)}))
+}
+
+func TestConsumerSchedulesRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	h := &handled{}
+	RunHandlerWith(newFakeConnection(ctx, fb), "Q", ConsumerOptions{PrefetchCount: 1, Workers: 1,
+		Retry: &RetryPolicy{Delays: []time.Duration{10 * time.Millisecond, time.Minute}, Parking: "Q.parked"}},
+		func(d *Delivery) (Decision, error) {
+			h.add(d)
+			return Reject, errors.New("oops")
+		})
+	fb.push("Q", amqp.Table{"trace": "1"}, "Lucy")
+	// the broker dead letters it back to Q once the TTL of its tier expires
+	for _, tier := range []string{"Q.retry.10ms", "Q.retry.1m0s"} {
+		select {
+		case m := <-fb.queue(tier):
+			fb.publish("Q", m)
+		case <-time.After(time.Second):
+			t.Fatalf("nothing scheduled in %s", tier)
+		}
+	}
+	select {
+	case m := <-fb.queue("Q.parked"):
+		assert.Equal(t, "Lucy", string(m.Body))
+		assert.Equal(t, "1", m.Headers["trace"])
+		assert.Equal(t, int64(2), m.Headers[retriesHeader])
+	case <-time.After(time.Second):
+		t.Fatal("nothing parked")
+	}
+	assert.Equal(t, 3, h.count())
+	for i, d := range h.deliveries {
+		assert.Equal(t, i, d.Retries)
+	}
+	eventually(t, func() bool { return len(fb.records()) == 3 })
+	assert.Equal(t, []string{"ack Lucy", "ack Lucy", "ack Lucy"}, fb.records())
+}
+
+func TestConsumerRequeuesUnconfirmedRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	fb.nacked, fb.muted = 1, 1
+	h := &handled{}
+	RunHandlerWith(newFakeConnection(ctx, fb), "Q", ConsumerOptions{PrefetchCount: 1, Workers: 1,
+		Retry: &RetryPolicy{Delays: []time.Duration{time.Minute}, Timeout: 50 * time.Millisecond}},
+		func(d *Delivery) (Decision, error) {
+			h.add(d)
+			return Reject, errors.New("oops")
+		})
+	fb.push("Q", nil, "Lucy")
+	// nacked, then never confirmed, then scheduled
+	eventually(t, func() bool { return len(fb.records()) == 3 })
+	assert.Equal(t, []string{"requeue Lucy", "requeue Lucy", "ack Lucy"}, fb.records())
+	assert.Equal(t, 3, h.count())
+	for _, d := range h.deliveries {
+		assert.Equal(t, 0, d.Retries)
+	}
+}
+
+func TestConsumerRequeuesUnroutableRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	RunHandlerWith(newFakeConnection(ctx, fb), "Q", ConsumerOptions{PrefetchCount: 1, Workers: 1,
+		Retry: &RetryPolicy{Delays: []time.Duration{time.Minute}}},
+		func(d *Delivery) (Decision, error) {
+			return Reject, errors.New("oops")
+		})
+	eventually(t, func() bool { return fb.routable("Q.retry.1m0s") })
+	fb.unbind("Q.retry.1m0s")
+	fb.push("Q", nil, "Lucy")
+	eventually(t, func() bool { return len(fb.records()) > 0 })
+	assert.Equal(t, "requeue Lucy", fb.records()[0])
+	assert.False(t, fb.routable("Q.retry.1m0s"))
+}
+
+func TestRepublishing(t *testing.T) {
+	m := republishing(&Delivery{Priority: 5, Type: "T", AppId: "A", Expiration: "60000", MessageId: "1",
+		Headers: amqp.Table{"trace": "1"}, Body: []byte("Lucy")}, 2)
+	assert.Equal(t, uint8(5), m.Priority)
+	assert.Equal(t, "T", m.Type)
+	assert.Equal(t, "A", m.AppId)
+	assert.Equal(t, "60000", m.Expiration)
+	assert.Equal(t, "1", m.MessageId)
+	assert.Equal(t, amqp.Table{"trace": "1", retriesHeader: int64(2)}, m.Headers)
+}
@@ -0,0 +1,263 @@
+package rabbit
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/streadway/amqp"
+	"golang.org/x/net/context"
+)
+
+// RabbitMQ direct reply-to pseudo queue, consumed with no-ack on the channel publishing requests
+const directReplyTo = "amq.rabbitmq.reply-to"
+
+// header carrying the server side error back to the caller
+const replyError = "x-reply-error"
+
+func correlationId() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+///////////////////////////////////////////////////////////////////////
+// Caller, request / reply over direct reply-to, replies are matched //
+// by correlation id, pending calls fail once the channel is gone  //
+// and it's reopened as long as the connection lives               //
+type Caller struct {
+	sync.Mutex
+	logger  logging.Logger
+	conn    *Connection
+	timeout time.Duration
+	qChan   qChannel
+	pending map[string]chan amqp.Delivery
+}
+
+// replies until qChan is gone, e.g. closed by a channel exception, then it's listened on again
+func (c *Caller) route(conn *Connection, qChan qChannel, replies <-chan amqp.Delivery,
+	closed chan *amqp.Error) {
+	for r := range replies {
+		c.Lock()
+		reply, ok := c.pending[r.CorrelationId]
+		delete(c.pending, r.CorrelationId)
+		c.Unlock()
+		if ok {
+			reply <- r
+		} else {
+			c.logger.Warnf("drop reply ( %s ), no pending call", r.CorrelationId)
+		}
+	}
+	select {
+	case err := <-closed:
+		if err != nil {
+			c.logger.Warnf("caller channel closed ( %s )", err.Error())
+		}
+	default:
+	}
+	if !c.lost(qChan) {
+		// replaced or reset since, by reconnecting or termination
+		return
+	}
+	for retry := 0; conn.State() == Connected && conn.pause(backoff(retry)); retry++ {
+		if err := c.listen(conn); err != nil {
+			c.logger.Error(err)
+		} else {
+			c.logger.Info("caller channel reopened")
+			return
+		}
+	}
+}
+
+func (c *Caller) interrupt() {
+	for id, reply := range c.pending {
+		close(reply)
+		delete(c.pending, id)
+	}
+}
+
+// the channel is gone, so are replies on their way to it
+func (c *Caller) reset(qChan qChannel) {
+	c.Lock()
+	if c.qChan != nil {
+		c.qChan.Close()
+	}
+	c.qChan = qChan
+	c.interrupt()
+	c.Unlock()
+}
+
+// pending calls fail at once if qChan is still the one listened on, false if it isn't
+func (c *Caller) lost(qChan qChannel) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.qChan != qChan {
+		return false
+	}
+	c.qChan.Close()
+	c.qChan = nil
+	c.interrupt()
+	return true
+}
+
+func (c *Caller) listen(conn *Connection) error {
+	if qChan, err := conn.channel(0, 0); err != nil {
+		return err
+	} else if replies, err := qChan.Consume(directReplyTo,
+		"",
+		true,
+		false,
+		false,
+		false, nil); err != nil {
+		qChan.Close()
+		return fmt.Errorf("consume ( %s ) failed ( %s )", directReplyTo, err.Error())
+	} else {
+		closed := qChan.NotifyClose(make(chan *amqp.Error, 1))
+		c.reset(qChan)
+		go c.route(conn, qChan, replies, closed)
+		return nil
+	}
+}
+
+func (c *Caller) run(conn *Connection) {
+	connected, reconnecting, closed := conn.register(c)
+	go func(connected, reconnecting, closed chan struct{}) {
+		for {
+			select {
+			case <-closed:
+				c.logger.Info("caller terminated")
+				c.reset(nil)
+				return
+			case <-reconnecting:
+				c.logger.Info("caller wait for reconnecting")
+				c.reset(nil)
+			case <-connected:
+				if err := c.listen(conn); err != nil {
+					c.logger.Error(err)
+				} else {
+					c.logger.Info("caller channel refreshed")
+				}
+			}
+		}
+	}(connected, reconnecting, closed)
+}
+
+func (c *Caller) request(exchange, routingKey string, body []byte, timeout time.Duration) (
+	string, chan amqp.Delivery, error) {
+	c.Lock()
+	qChan := c.qChan
+	if qChan == nil {
+		c.Unlock()
+		return "", nil, fmt.Errorf("caller disconnected, ( %s ) not sent", string(body))
+	}
+	// pending ahead of publishing, the reply may beat Publish back
+	id, reply := correlationId(), make(chan amqp.Delivery, 1)
+	c.pending[id] = reply
+	c.Unlock()
+	if err := qChan.Publish(exchange, routingKey, false, false, amqp.Publishing{
+		ContentType:   "text/plain",
+		CorrelationId: id,
+		ReplyTo:       directReplyTo,
+		// nobody waits for it after that
+		Expiration: strconv.FormatInt(int64(timeout/time.Millisecond), 10),
+		Body:       body,
+	}); err != nil {
+		c.forget(id)
+		return "", nil, err
+	}
+	return id, reply, nil
+}
+
+func (c *Caller) forget(id string) {
+	c.Lock()
+	delete(c.pending, id)
+	c.Unlock()
+}
+
+// wait for the reply until ctx is done or the caller's timeout, whichever first
+func (c *Caller) Call(ctx context.Context, exchange, routingKey string, body []byte) ([]byte, error) {
+	timeout := c.timeout
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("call ( %s, %s ) timeout", exchange, routingKey)
+	}
+	id, reply, err := c.request(exchange, routingKey, body, timeout)
+	if err != nil {
+		c.logger.Warnf("call ( %s, %s ) failed ( %s )", exchange, routingKey, err.Error())
+		return nil, err
+	}
+	select {
+	case r, ok := <-reply:
+		if !ok {
+			return nil, fmt.Errorf("call ( %s, %s ) interrupted, channel closed", exchange, routingKey)
+		} else if e, failed := r.Headers[replyError].(string); failed {
+			return nil, fmt.Errorf("call ( %s, %s ) failed remotely ( %s )", exchange, routingKey, e)
+		} else {
+			return r.Body, nil
+		}
+	case <-ctx.Done():
+		c.forget(id)
+		return nil, ctx.Err()
+	case <-time.After(timeout):
+		c.forget(id)
+		return nil, fmt.Errorf("call ( %s, %s ) timeout ( %+v )", exchange, routingKey, timeout)
+	}
+}
+
+// timeout applies to every call, unless ctx has an earlier deadline
+func NewCaller(conn *Connection, timeout time.Duration) *Caller {
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	c := &Caller{logger: logging.GetLogger(" ⓠ caller "), conn: conn, timeout: timeout,
+		pending: make(map[string]chan amqp.Delivery)}
+	c.run(conn)
+	conn.start()
+	return c
+}
+
+///////////////////////////////////////////////////////////////
+// ReplyHandler, what it returns is published to the caller //
+type ReplyHandler func(*Delivery) ([]byte, error)
+
+// a handler error is replied as well, the request is acked once replied,
+// and rejected if the reply could not be published
+func ReplyWith(p *Publisher, handler ReplyHandler) Handler {
+	return func(d *Delivery) (Decision, error) {
+		body, err := handler(d)
+		if d.ReplyTo == "" {
+			if err != nil {
+				return Reject, err
+			}
+			return Ack, nil
+		}
+		reply := NewMessage("", d.ReplyTo, body).SetProperties(amqp.Publishing{ContentType: "text/plain",
+			CorrelationId: d.CorrelationId})
+		if err != nil {
+			reply.SetHeader(replyError, err.Error())
+		}
+		if e := p.PublishSync(reply); e != nil {
+			return Reject, fmt.Errorf("reply ( %s ) failed ( %s )", d.CorrelationId, e.Error())
+		}
+		return Ack, err
+	}
+}
+
+// N servers per Queue reusing Connection, replies go through a Publisher upon the same Connection
+func RunServerUpon(conn *Connection, qName string, prefetchCount, prefetchSize, workers int,
+	handler ReplyHandler) {
+	replyWith := ReplyWith(NewPublisher(conn, workers, 0), handler)
+	for id := 0; id < workers; id++ {
+		c := newConsumer(id, qName, prefetchCount, prefetchSize, replyWith)
+		c.run(conn)
+	}
+	conn.start()
+}
@@ -0,0 +1,93 @@
+package rabbit
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/context"
+)
+
+func TestCallWhileDisconnected(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewCaller(NewConnection(ctx, "guest", "guest", "127.0.0.1:1"), time.Second)
+	_, err := c.Call(ctx, "", "Q", []byte("Lucy"))
+	assert.NotNil(t, err)
+	expired, done := context.WithTimeout(ctx, -time.Second)
+	defer done()
+	_, err = c.Call(expired, "", "Q", []byte("Lily"))
+	assert.NotNil(t, err)
+}
+
+func TestReplyWith(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := NewPublisher(NewConnection(ctx, "guest", "guest", "127.0.0.1:1"), 1, 0)
+	replied := ReplyWith(p, func(d *Delivery) ([]byte, error) { return d.Body, nil })
+	decision, err := replied(&Delivery{Body: []byte("Lucy")})
+	assert.Equal(t, Ack, decision)
+	assert.Nil(t, err)
+	decision, err = ReplyWith(p, func(d *Delivery) ([]byte, error) { return nil, errors.New("oops") })(
+		&Delivery{Body: []byte("Lucy")})
+	assert.Equal(t, Reject, decision)
+	assert.NotNil(t, err)
+	// no way to reply while disconnected
+	decision, err = replied(&Delivery{ReplyTo: directReplyTo, CorrelationId: "1", Body: []byte("Lily")})
+	assert.Equal(t, Reject, decision)
+	assert.NotNil(t, err)
+}
+
+func TestCallerReopensChannel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fb := newFakeBroker()
+	conn := newFakeConnection(ctx, fb)
+	release := make(chan struct{})
+	RunServerUpon(conn, "Q", 2, 0, 2, func(d *Delivery) ([]byte, error) {
+		if string(d.Body) == "slow" {
+			<-release
+		}
+		return append([]byte("hi "), d.Body...), nil
+	})
+	defer close(release)
+	c := NewCaller(conn, 200*time.Millisecond)
+	listening := func() qChannel {
+		c.Lock()
+		defer c.Unlock()
+		return c.qChan
+	}
+	eventually(t, func() bool { return listening() != nil })
+	reply, err := c.Call(ctx, "", "Q", []byte("Lucy"))
+	assert.Nil(t, err)
+	assert.Equal(t, "hi Lucy", string(reply))
+
+	// a channel exception fails the pending call at once
+	interrupted := make(chan error, 1)
+	go func() {
+		_, err := c.Call(ctx, "", "Q", []byte("slow"))
+		interrupted <- err
+	}()
+	eventually(t, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		return len(c.pending) == 1
+	})
+	listening().(*fakeChannel).shutdown(&amqp.Error{Code: amqp.NotFound, Reason: "NOT_FOUND - no exchange"})
+	select {
+	case err := <-interrupted:
+		assert.Contains(t, err.Error(), "interrupted")
+	case <-time.After(100 * time.Millisecond):
+		t.Fatal("pending call not failed")
+	}
+	// and the channel is reopened without the connection reconnecting
+	eventually(t, func() bool {
+		reply, err := c.Call(ctx, "", "Q", []byte("Lily"))
+		return err == nil && string(reply) == "hi Lily"
+	})
+	fb.Lock()
+	assert.Equal(t, 1, fb.dials)
+	fb.Unlock()
+}
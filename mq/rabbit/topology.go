@@ -0,0 +1,138 @@
+package rabbit
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/streadway/amqp"
+)
+
+type Exchange struct {
+	Name       string
+	Kind       string // direct, fanout, topic or headers
+	Durable    bool
+	AutoDelete bool
+	Internal   bool
+	Args       amqp.Table
+}
+
+type Queue struct {
+	Name       string
+	Durable    bool
+	AutoDelete bool
+	Exclusive  bool
+	Args       amqp.Table
+}
+
+type Binding struct {
+	Queue    string
+	Exchange string
+	Key      string
+	Args     amqp.Table
+}
+
+//////////////////////////////////////////////////////////////////////
+// Topology, declared in order of exchanges, queues then bindings, //
+// redeclaring with the same arguments is a no-op for the broker  //
+type Topology struct {
+	Exchanges []Exchange
+	Queues    []Queue
+	Bindings  []Binding
+}
+
+func (t *Topology) Merge(others ...*Topology) *Topology {
+	for _, o := range others {
+		t.Exchanges = append(t.Exchanges, o.Exchanges...)
+		t.Queues = append(t.Queues, o.Queues...)
+		t.Bindings = append(t.Bindings, o.Bindings...)
+	}
+	return t
+}
+
+func (t *Topology) declare(qChan qChannel) error {
+	for _, e := range t.Exchanges {
+		if err := qChan.ExchangeDeclare(e.Name, e.Kind, e.Durable, e.AutoDelete, e.Internal, false,
+			e.Args); err != nil {
+			return fmt.Errorf("declare exchange ( %s ) failed ( %s )", e.Name, err.Error())
+		}
+	}
+	for _, q := range t.Queues {
+		if _, err := qChan.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false,
+			q.Args); err != nil {
+			return fmt.Errorf("declare queue ( %s ) failed ( %s )", q.Name, err.Error())
+		}
+	}
+	for _, b := range t.Bindings {
+		if err := qChan.QueueBind(b.Queue, b.Key, b.Exchange, false, b.Args); err != nil {
+			return fmt.Errorf("bind ( %s, %s, %s ) failed ( %s )", b.Queue, b.Exchange, b.Key, err.Error())
+		}
+	}
+	return nil
+}
+
+// on its own channel, a failed declare closes the channel
+func (t *Topology) declareUpon(conn *Connection) error {
+	if qChan, err := conn.channel(0, 0); err != nil {
+		return err
+	} else {
+		defer qChan.Close()
+		return t.declare(qChan)
+	}
+}
+
+func (t *Topology) run(conn *Connection) {
+	logger := logging.GetLogger(" ⓠ topology ")
+	connected, reconnecting, closed := conn.register(t)
+	go func(connected, reconnecting, closed chan struct{}) {
+		for {
+			select {
+			case <-closed:
+				return
+			case <-reconnecting:
+			case <-connected:
+				if err := t.declareUpon(conn); err != nil {
+					logger.Error(err)
+				} else {
+					logger.Info("topology declared")
+				}
+			}
+		}
+	}(connected, reconnecting, closed)
+}
+
+// (re)declare t upon every (re)connect, e.g. for publishers
+func DeclareTopology(conn *Connection, t *Topology) {
+	t.run(conn)
+	conn.start()
+}
+
+///////////////////////////////////////////////////////////////////////////////////////
+// Retry Topology, the ops works retryableConsumer relies on:                      //
+// 1: Topic Exchange=exchange with queue bound by key                              //
+// 2: queue has DLX=exchange.dlx and DLK=key, Nack(requeue:false) dead letters it  //
+// 3: DL Topic Exchange=exchange.dlx with queue.retry bound by key                 //
+// 4: queue.retry has TTL=delay and DLX=exchange with DLK=key, back to queue after //
+func RetryTopology(exchange, queue, key string, delay time.Duration) *Topology {
+	dlx := exchange + ".dlx"
+	retry := queue + ".retry"
+	return &Topology{
+		Exchanges: []Exchange{
+			{Name: exchange, Kind: amqp.ExchangeTopic, Durable: true},
+			{Name: dlx, Kind: amqp.ExchangeTopic, Durable: true},
+		},
+		Queues: []Queue{
+			{Name: queue, Durable: true, Args: amqp.Table{
+				"x-dead-letter-exchange":    dlx,
+				"x-dead-letter-routing-key": key}},
+			{Name: retry, Durable: true, Args: amqp.Table{
+				"x-message-ttl":             int64(delay / time.Millisecond),
+				"x-dead-letter-exchange":    exchange,
+				"x-dead-letter-routing-key": key}},
+		},
+		Bindings: []Binding{
+			{Queue: queue, Exchange: exchange, Key: key},
+			{Queue: retry, Exchange: dlx, Key: key},
+		},
+	}
+}
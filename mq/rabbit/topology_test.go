@@ -0,0 +1,34 @@
+package rabbit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTopology(t *testing.T) {
+	topology := RetryTopology("E", "Q", "K", time.Second*10)
+	assert.Equal(t, []Exchange{
+		{Name: "E", Kind: amqp.ExchangeTopic, Durable: true},
+		{Name: "E.dlx", Kind: amqp.ExchangeTopic, Durable: true}}, topology.Exchanges)
+	assert.Equal(t, 2, len(topology.Queues))
+	assert.Equal(t, "Q", topology.Queues[0].Name)
+	assert.Equal(t, "E.dlx", topology.Queues[0].Args["x-dead-letter-exchange"])
+	assert.Equal(t, "K", topology.Queues[0].Args["x-dead-letter-routing-key"])
+	assert.Equal(t, "Q.retry", topology.Queues[1].Name)
+	assert.Equal(t, int64(10000), topology.Queues[1].Args["x-message-ttl"])
+	assert.Equal(t, "E", topology.Queues[1].Args["x-dead-letter-exchange"])
+	assert.Equal(t, []Binding{
+		{Queue: "Q", Exchange: "E", Key: "K"},
+		{Queue: "Q.retry", Exchange: "E.dlx", Key: "K"}}, topology.Bindings)
+}
+
+func TestTopologyMerge(t *testing.T) {
+	topology := (&Topology{}).Merge(RetryTopology("E", "Q1", "K1", time.Second),
+		RetryTopology("E", "Q2", "K2", time.Second))
+	assert.Equal(t, 4, len(topology.Exchanges))
+	assert.Equal(t, 4, len(topology.Queues))
+	assert.Equal(t, 4, len(topology.Bindings))
+}
@@ -0,0 +1,104 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/samwooo/bolsa/mq"
+)
+
+//////////////////////////////////////////////////////////////////////
+// Broker, mq.Broker upon SQS, topics are queue urls, headers travel //
+// as string message attributes                                    //
+type Broker struct {
+	sync.Mutex
+	ctx        context.Context
+	cancel     context.CancelFunc
+	qRegion    string
+	qWait      int64
+	qWorkers   int
+	opts       []Option
+	publishers map[string]*Publisher
+	drained    []<-chan struct{} // of every subscription
+	closed     bool
+}
+
+func (b *Broker) publisher(qUrl string) (*Publisher, error) {
+	b.Lock()
+	defer b.Unlock()
+	if p, ok := b.publishers[qUrl]; ok {
+		return p, nil
+	} else if p, err := NewPublisher(b.ctx, b.qRegion, qUrl, b.opts...); err != nil {
+		return nil, err
+	} else {
+		b.publishers[qUrl] = p
+		return p, nil
+	}
+}
+
+func (b *Broker) Publish(m *mq.Message) error {
+	if p, err := b.publisher(m.Topic); err != nil {
+		return err
+	} else {
+		pub := Publishing{Body: string(m.Body)}
+		if len(m.Headers) > 0 {
+			pub.Attributes = make(map[string]*sqs.MessageAttributeValue, len(m.Headers))
+			for k, v := range m.Headers {
+				pub.Attributes[k] = stringAttribute(fmt.Sprint(v))
+			}
+		}
+		_, err := p.Send(pub)
+		return err
+	}
+}
+
+// a handler error leaves the message to the queue's redrive policy
+func (b *Broker) Subscribe(topic string, handler mq.Handler) error {
+	if c, err := NewMessageConsumer(b.ctx, b.qRegion, topic, b.qWait, b.qWorkers, func(m *Message) error {
+		headers := make(map[string]interface{}, len(m.MessageAttributes))
+		for k, v := range m.MessageAttributes {
+			headers[k] = aws.StringValue(v.StringValue)
+		}
+		retries := m.ReceiveCount - 1
+		if retries < 0 {
+			retries = 0
+		}
+		return handler(&mq.Message{Id: m.Id, Topic: topic, Headers: headers, Body: []byte(m.Body),
+			Retries: retries})
+	}, b.opts...); err != nil {
+		return err
+	} else {
+		b.Lock()
+		defer b.Unlock()
+		if b.closed {
+			c.Close()
+			return fmt.Errorf("subscribe ( %s ) failed ( broker closed )", topic)
+		}
+		b.drained = append(b.drained, c.Run())
+		return nil
+	}
+}
+
+// in-flight messages get handled, it returns once every subscription is drained
+func (b *Broker) Close() error {
+	b.Lock()
+	b.closed = true
+	drained := b.drained
+	b.Unlock()
+	b.cancel()
+	for _, d := range drained {
+		<-d
+	}
+	return nil
+}
+
+func NewBroker(ctx context.Context, qRegion string, qWait int64, qWorkers int, opts ...Option) *Broker {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Broker{ctx: ctx, cancel: cancel, qRegion: qRegion, qWait: qWait, qWorkers: qWorkers, opts: opts,
+		publishers: make(map[string]*Publisher)}
+}
+
+var _ mq.Broker = (*Broker)(nil)
@@ -0,0 +1,70 @@
+package sqs
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/mq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBrokerPublish(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	b := NewBroker(context.Background(), "local", 1, 1, fs.options()...)
+	defer b.Close()
+	assert.Nil(t, b.Publish(&mq.Message{Topic: fs.url("queue"), Body: []byte("Lucy"),
+		Headers: map[string]interface{}{"a": 1}}))
+	assert.Nil(t, b.Publish(&mq.Message{Topic: fs.url("queue"), Body: []byte("Lily")}))
+	assert.Equal(t, 1, len(b.publishers))
+	assert.Equal(t, 2, fs.requested())
+	assert.Equal(t, []string{"Lucy", "Lily"}, fs.bodies("queue"))
+}
+
+func TestBrokerSubscribe(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	b := NewBroker(context.Background(), "local", 0, 1, fs.options()...)
+	defer b.Close()
+	received := make(chan *mq.Message, 1)
+	assert.Nil(t, b.Subscribe(fs.url("Q"), func(m *mq.Message) error {
+		received <- m
+		return nil
+	}))
+	assert.Nil(t, b.Publish(&mq.Message{Topic: fs.url("Q"), Body: []byte("Lucy"),
+		Headers: map[string]interface{}{"name": "Lily"}}))
+	m := <-received
+	assert.Equal(t, "Lucy", string(m.Body))
+	assert.Equal(t, "Lily", m.Headers["name"])
+	assert.Equal(t, 0, m.Retries)
+}
+
+func TestBrokerCloseWaitsForSubscriptions(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	b := NewBroker(context.Background(), "local", 0, 1, fs.options()...)
+	started, release := make(chan struct{}), make(chan struct{})
+	assert.Nil(t, b.Subscribe(fs.url("Q"), func(m *mq.Message) error {
+		close(started)
+		<-release
+		return nil
+	}))
+	fs.push("Q", "Lucy", 0)
+	<-started
+	closed := make(chan error, 1)
+	go func() { closed <- b.Close() }()
+	select {
+	case <-closed:
+		t.Fatal("closed while handling")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-closed:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not drained")
+	}
+	assert.NotNil(t, b.Subscribe(fs.url("Q"), func(m *mq.Message) error { return nil }))
+}
@@ -0,0 +1,68 @@
+package sqs
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/samwooo/bolsa/mq/codec"
+)
+
+// message attributes standing in for AMQP's content properties
+const (
+	contentTypeAttribute     = "content-type"
+	contentEncodingAttribute = "content-encoding"
+)
+
+func stringAttribute(value string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{DataType: aws.String("String"), StringValue: aws.String(value)}
+}
+
+// SQS bodies are text, base64 unless the codec is textual
+func Encode(v interface{}, c codec.Codec) (Publishing, error) {
+	if data, err := c.Marshal(v); err != nil {
+		return Publishing{}, fmt.Errorf("encode ( %+v ) failed ( %s )", v, err.Error())
+	} else {
+		pub := Publishing{Attributes: map[string]*sqs.MessageAttributeValue{
+			contentTypeAttribute: stringAttribute(c.ContentType())}}
+		if c.ContentEncoding() != "" {
+			pub.Attributes[contentEncodingAttribute] = stringAttribute(c.ContentEncoding())
+		}
+		if codec.Textual(c) {
+			pub.Body = string(data)
+		} else {
+			pub.Body = base64.StdEncoding.EncodeToString(data)
+		}
+		return pub, nil
+	}
+}
+
+func attributeOf(m *Message, name string) string {
+	if attribute, ok := m.MessageAttributes[name]; ok && attribute != nil {
+		return aws.StringValue(attribute.StringValue)
+	}
+	return ""
+}
+
+// handler gets a pointer to a new value of sample's type, JSON if the message says nothing
+func Decoded(sample interface{}, handler func(interface{}, *Message) error) Handler {
+	return func(m *Message) error {
+		contentType, contentEncoding := attributeOf(m, contentTypeAttribute), attributeOf(m, contentEncodingAttribute)
+		c, err := codec.For(contentType, contentEncoding)
+		if err != nil {
+			return err
+		}
+		data := []byte(m.Body)
+		if !codec.Textual(c) {
+			if data, err = base64.StdEncoding.DecodeString(m.Body); err != nil {
+				return fmt.Errorf("decode ( %s ) failed ( %s )", m.Id, err.Error())
+			}
+		}
+		if v, err := codec.Decode(contentType, contentEncoding, data, sample); err != nil {
+			return err
+		} else {
+			return handler(v, m)
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package sqs
+
+import (
+	"testing"
+
+	"github.com/samwooo/bolsa/mq/codec"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecoded(t *testing.T) {
+	var decoded interface{}
+	handler := Decoded([]string{}, func(v interface{}, m *Message) error {
+		decoded = v
+		return nil
+	})
+	for _, c := range []codec.Codec{codec.JSON, codec.Gob, codec.GzipJSON} {
+		pub, err := Encode([]string{"Lucy", "Lily"}, c)
+		assert.Nil(t, err)
+		assert.Nil(t, handler(&Message{Body: pub.Body, MessageAttributes: pub.Attributes}))
+		assert.Equal(t, &[]string{"Lucy", "Lily"}, decoded)
+	}
+	pub, _ := Encode([]string{"Lucy"}, codec.JSON)
+	assert.Equal(t, `["Lucy"]`, pub.Body)
+	// nothing says what it is, JSON then
+	assert.Nil(t, handler(&Message{Body: `["Lily"]`}))
+	assert.Equal(t, &[]string{"Lily"}, decoded)
+	assert.NotNil(t, handler(&Message{Body: "Lily"}))
+}
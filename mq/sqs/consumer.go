@@ -4,7 +4,9 @@ import (
 	"context"
 	"math"
 	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/samwooo/bolsa/common"
@@ -13,49 +15,115 @@ import (
 
 type Consumer struct {
 	ctx      context.Context
+	cancel   context.CancelFunc
 	logger   logging.Logger
 	qService *sqs.SQS
 	qUrl     string
 	qWait    int64
+	qBatch   int64
 	qWorkers int
-	handler  MessageHandler
+	handler  handler
+	policy   Policy
 	ready    atomic.Value
 }
 
-func (c *Consumer) connect(qRegion string) {
+func (c *Consumer) connect(qRegion string, opts ...Option) (err error) {
 	c.ready.Store(false)
-	c.qService = connect(c.logger, qRegion)
-	c.ready.Store(true)
+	if c.qService, err = connect(c.logger, qRegion, opts...); err == nil {
+		c.ready.Store(true)
+	}
+	return
+}
+
+func (c *Consumer) tail() string {
+	return c.qUrl[int(math.Max(float64(len(c.qUrl)-9), float64(0))):]
+}
+
+// stop polling, in-flight messages still get handled
+func (c *Consumer) Close() {
+	c.ready.Store(false)
+	c.cancel()
+}
+
+func (c *Consumer) running() bool {
+	ready, ok := c.ready.Load().(bool)
+	return ok && ready && c.ctx.Err() == nil
+}
+
+func (c *Consumer) work() {
+	for c.running() {
+		// failed handlers or settles are up to the policy, only a failed receive backs off, e.g. network errors
+		if received, _ := consume(c.ctx, c.logger, c.qService, c.qUrl, c.qWait, c.qBatch, c.handler,
+			c.policy); !received && c.running() {
+			select {
+			case <-c.ctx.Done():
+			case <-time.After(time.Second):
+			}
+		}
+	}
 }
 
-func (c *Consumer) Close() { c.ready.Store(false) }
+// messages per receive, up to 10
+func (c *Consumer) SetBatch(qBatch int64) *Consumer {
+	c.qBatch = batchOf(qBatch)
+	return c
+}
+
+// what to do with a message once its handler failed
+func (c *Consumer) SetFailurePolicy(policy Policy) *Consumer {
+	c.policy = policy
+	return c
+}
 
-func (c *Consumer) Run() {
+// poll until ctx cancelled or Close, drained closes once every in-flight message is done
+func (c *Consumer) Run() (drained <-chan struct{}) {
 	common.TerminateIf(c.ctx,
 		func() {
-			c.logger.Infof("cancellation, ( ...%s ) terminated", c.qUrl[int(math.Max(
-				float64(len(c.qUrl)-9), float64(0))):])
+			c.logger.Infof("cancellation, ( ...%s ) terminated", c.tail())
 			c.Close()
 		},
 		func(s os.Signal) {
-			c.logger.Infof("signal ( %+v ), ( ...%s ) terminated", s, c.qUrl[int(math.Max(
-				float64(len(c.qUrl)-9), float64(0))):])
+			c.logger.Infof("signal ( %+v ), ( ...%s ) terminated", s, c.tail())
 			c.Close()
 		})
 
+	var wg sync.WaitGroup
+	wg.Add(c.qWorkers)
 	for i := 0; i < c.qWorkers; i++ {
 		go func() {
-			if ready, ok := c.ready.Load().(bool); ok && ready {
-				consume(c.ctx, c.logger, c.qService, c.qUrl, c.qWait, c.handler)
-			}
+			c.work()
+			wg.Done()
 		}()
 	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		c.logger.Infof("( ...%s ) drained", c.tail())
+		close(done)
+	}()
+	return done
+}
+
+func newConsumer(ctx context.Context, qRegion, qUrl string, qWait int64, qWorkers int, handler handler,
+	opts ...Option) (*Consumer, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	c := &Consumer{ctx: ctx, cancel: cancel, logger: logging.GetLogger(" ⓠ "), qUrl: qUrl, qWait: qWait,
+		qBatch: 1, qWorkers: qWorkers, handler: handler, ready: atomic.Value{}}
+	if err := c.connect(qRegion, opts...); err != nil {
+		cancel()
+		return nil, err
+	} else {
+		return c, nil
+	}
 }
 
 func NewConsumer(ctx context.Context, qRegion, qUrl string, qWait int64, qWorkers int,
-	handler MessageHandler) *Consumer {
-	c := &Consumer{ctx: ctx, logger: logging.GetLogger(" ⓠ "), qUrl: qUrl, qWait: qWait, qWorkers: qWorkers,
-		handler: handler, ready: atomic.Value{}}
-	c.connect(qRegion)
-	return c
+	handler MessageHandler, opts ...Option) (*Consumer, error) {
+	return newConsumer(ctx, qRegion, qUrl, qWait, qWorkers, handler, opts...)
+}
+
+// handler sees attributes and receive count along with the body
+func NewMessageConsumer(ctx context.Context, qRegion, qUrl string, qWait int64, qWorkers int,
+	handler Handler, opts ...Option) (*Consumer, error) {
+	return newConsumer(ctx, qRegion, qUrl, qWait, qWorkers, handler, opts...)
 }
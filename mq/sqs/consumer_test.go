@@ -0,0 +1,85 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// wait for cond up to timeout
+func eventually(t *testing.T, timeout time.Duration, cond func() bool) {
+	for start := time.Now(); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > timeout {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+func TestConsumerDeadLettersAfterMaxReceives(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.push("Q", "Lucy", 0)
+	fs.push("Q", "fail", 0)
+	var mu sync.Mutex
+	var receives []int
+	c, err := NewMessageConsumer(context.Background(), "local", fs.url("Q"), 0, 1, func(m *Message) error {
+		if m.Body == "fail" {
+			mu.Lock()
+			receives = append(receives, m.ReceiveCount)
+			mu.Unlock()
+			return errors.New("oops")
+		}
+		return nil
+	}, fs.options()...)
+	assert.Nil(t, err)
+	c.SetBatch(10).SetFailurePolicy(Policy{Visibility: ResetVisibility, MaxReceives: 3, DeadLetter: fs.url("DLQ")})
+	defer c.Close()
+	drained := c.Run()
+	// failed messages don't hold the worker back
+	start := time.Now()
+	eventually(t, 5*time.Second, func() bool { return len(fs.bodies("Q")) == 0 })
+	assert.True(t, time.Since(start) < time.Second)
+	c.Close()
+	<-drained
+	assert.Equal(t, []string{"fail"}, fs.bodies("DLQ"))
+	assert.Equal(t, []int{1, 2, 3}, receives)
+}
+
+func TestConsumerKeepsVisibility(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	// received before, e.g. by a consumer that crashed
+	fs.push("Q", "Lucy", 4)
+	c, err := NewConsumer(context.Background(), "local", fs.url("Q"), 0, 2, func(body string) error {
+		return errors.New("oops")
+	}, fs.options()...)
+	assert.Nil(t, err)
+	defer c.Close()
+	drained := c.Run()
+	eventually(t, time.Second, func() bool { return fs.receives("Q", "Lucy") == 5 })
+	time.Sleep(100 * time.Millisecond)
+	c.Close()
+	<-drained
+	// invisible for the default visibility timeout
+	assert.Equal(t, 5, fs.receives("Q", "Lucy"))
+}
+
+func TestConsumerRecoversFromErrors(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.fail = 1
+	fs.push("Q", "Lucy", 0)
+	c, err := NewConsumer(context.Background(), "local", fs.url("Q"), 0, 1, func(body string) error {
+		return nil
+	}, fs.options()...)
+	assert.Nil(t, err)
+	defer c.Close()
+	drained := c.Run()
+	eventually(t, 3*time.Second, func() bool { return len(fs.bodies("Q")) == 0 })
+	c.Close()
+	<-drained
+}
@@ -0,0 +1,247 @@
+package sqs
+
+import (
+	"crypto/md5"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type fakeMessage struct {
+	id         string
+	body       string
+	attributes map[string]string // string message attributes
+	receives   int
+	visibleAt  time.Time
+	receipt    string
+}
+
+///////////////////////////////////////////////////////////////////////////
+// fakeSQS, an SQS stand-in on localhost keeping queues by url path, it  //
+// honours delays and visibility timeouts                               //
+type fakeSQS struct {
+	sync.Mutex
+	*httptest.Server
+	queues   map[string][]*fakeMessage
+	fail     int    // requests failed ahead, as if the queue didn't exist
+	throttle int    // requests throttled ahead
+	invalid  string // body of the batch entries failed
+	requests int
+	sequence int
+}
+
+func (fs *fakeSQS) url(queue string) string { return fs.URL + "/" + queue }
+
+// e.g. a message received before
+func (fs *fakeSQS) push(queue, body string, receives int) {
+	fs.Lock()
+	defer fs.Unlock()
+	fs.sequence++
+	fs.queues[queue] = append(fs.queues[queue], &fakeMessage{id: strconv.Itoa(fs.sequence), body: body,
+		attributes: map[string]string{}, receives: receives})
+}
+
+func (fs *fakeSQS) bodies(queue string) (bodies []string) {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, m := range fs.queues[queue] {
+		bodies = append(bodies, m.body)
+	}
+	return
+}
+
+func (fs *fakeSQS) receives(queue, body string) int {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, m := range fs.queues[queue] {
+		if m.body == body {
+			return m.receives
+		}
+	}
+	return 0
+}
+
+// how long body stays invisible, 0 if it's visible or gone
+func (fs *fakeSQS) invisible(queue, body string) time.Duration {
+	fs.Lock()
+	defer fs.Unlock()
+	for _, m := range fs.queues[queue] {
+		if m.body == body && m.visibleAt.After(time.Now()) {
+			return time.Until(m.visibleAt)
+		}
+	}
+	return 0
+}
+
+func (fs *fakeSQS) requested() int {
+	fs.Lock()
+	defer fs.Unlock()
+	return fs.requests
+}
+
+// string attributes of the message under prefix, e.g. SendMessageBatchRequestEntry.1.
+func attributesOf(form func(string) string, prefix string) map[string]string {
+	attributes := map[string]string{}
+	for i := 1; form(fmt.Sprintf("%sMessageAttribute.%d.Name", prefix, i)) != ""; i++ {
+		attributes[form(fmt.Sprintf("%sMessageAttribute.%d.Name", prefix, i))] =
+			form(fmt.Sprintf("%sMessageAttribute.%d.Value.StringValue", prefix, i))
+	}
+	return attributes
+}
+
+func (fs *fakeSQS) send(queue string, form func(string) string, prefix string) *fakeMessage {
+	fs.sequence++
+	m := &fakeMessage{id: strconv.Itoa(fs.sequence), body: form(prefix + "MessageBody"),
+		attributes: attributesOf(form, prefix)}
+	if delay, err := strconv.Atoi(form(prefix + "DelaySeconds")); err == nil {
+		m.visibleAt = time.Now().Add(time.Duration(delay) * time.Second)
+	}
+	fs.queues[queue] = append(fs.queues[queue], m)
+	return m
+}
+
+func (fs *fakeSQS) find(queue, receipt string) (int, *fakeMessage) {
+	for i, m := range fs.queues[queue] {
+		if m.receipt != "" && m.receipt == receipt {
+			return i, m
+		}
+	}
+	return -1, nil
+}
+
+func (fs *fakeSQS) delete(queue, receipt string) bool {
+	if i, _ := fs.find(queue, receipt); i >= 0 {
+		fs.queues[queue] = append(fs.queues[queue][:i], fs.queues[queue][i+1:]...)
+		return true
+	}
+	return false
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func (fs *fakeSQS) receive(w http.ResponseWriter, queue string, form func(string) string) {
+	max, _ := strconv.Atoi(form("MaxNumberOfMessages"))
+	if max <= 0 {
+		max = 1
+	}
+	visibility := 30 * time.Second
+	if v, err := strconv.Atoi(form("VisibilityTimeout")); err == nil {
+		visibility = time.Duration(v) * time.Second
+	}
+	fmt.Fprint(w, `<ReceiveMessageResponse><ReceiveMessageResult>`)
+	now := time.Now()
+	for _, m := range fs.queues[queue] {
+		if max == 0 {
+			break
+		} else if m.visibleAt.After(now) {
+			continue
+		}
+		max--
+		fs.sequence++
+		m.receives++
+		m.receipt = fmt.Sprintf("%s-%d", m.id, fs.sequence)
+		m.visibleAt = now.Add(visibility)
+		fmt.Fprintf(w, `<Message><MessageId>%s</MessageId><ReceiptHandle>%s</ReceiptHandle>`+
+			`<MD5OfBody>%x</MD5OfBody><Body>%s</Body>`+
+			`<Attribute><Name>ApproximateReceiveCount</Name><Value>%d</Value></Attribute>`,
+			m.id, m.receipt, md5.Sum([]byte(m.body)), escape(m.body), m.receives)
+		for k, v := range m.attributes {
+			fmt.Fprintf(w, `<MessageAttribute><Name>%s</Name><Value><DataType>String</DataType>`+
+				`<StringValue>%s</StringValue></Value></MessageAttribute>`, escape(k), escape(v))
+		}
+		fmt.Fprint(w, `</Message>`)
+	}
+	fmt.Fprint(w, `</ReceiveMessageResult></ReceiveMessageResponse>`)
+}
+
+func (fs *fakeSQS) serve(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	form := r.Form.Get
+	queue := strings.TrimPrefix(r.URL.Path, "/")
+	if u := form("QueueUrl"); u != "" {
+		queue = strings.TrimPrefix(u, fs.URL+"/")
+	}
+	fs.Lock()
+	defer fs.Unlock()
+	fs.requests++
+	if fs.throttle > 0 {
+		fs.throttle--
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>Throttling</Code>`+
+			`<Message>Rate exceeded</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+		return
+	} else if fs.fail > 0 {
+		fs.fail--
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprint(w, `<ErrorResponse><Error><Type>Sender</Type><Code>AWS.SimpleQueueService.NonExistentQueue`+
+			`</Code><Message>injected</Message></Error><RequestId>1</RequestId></ErrorResponse>`)
+		return
+	}
+	switch form("Action") {
+	case "ReceiveMessage":
+		fs.receive(w, queue, form)
+	case "SendMessage":
+		m := fs.send(queue, form, "")
+		fmt.Fprintf(w, `<SendMessageResponse><SendMessageResult><MD5OfMessageBody>%x</MD5OfMessageBody>`+
+			`<MessageId>%s</MessageId></SendMessageResult></SendMessageResponse>`, md5.Sum([]byte(m.body)), m.id)
+	case "SendMessageBatch":
+		fmt.Fprint(w, `<SendMessageBatchResponse><SendMessageBatchResult>`)
+		for i := 1; form(fmt.Sprintf("SendMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			prefix := fmt.Sprintf("SendMessageBatchRequestEntry.%d.", i)
+			if id := form(prefix + "Id"); fs.invalid != "" && form(prefix+"MessageBody") == fs.invalid {
+				fmt.Fprintf(w, `<BatchResultErrorEntry><Id>%s</Id><Code>InvalidMessageContents</Code>`+
+					`<SenderFault>true</SenderFault><Message>invalid</Message></BatchResultErrorEntry>`, id)
+			} else {
+				m := fs.send(queue, form, prefix)
+				fmt.Fprintf(w, `<SendMessageBatchResultEntry><Id>%s</Id><MessageId>%s</MessageId>`+
+					`<MD5OfMessageBody>%x</MD5OfMessageBody></SendMessageBatchResultEntry>`,
+					id, m.id, md5.Sum([]byte(m.body)))
+			}
+		}
+		fmt.Fprint(w, `</SendMessageBatchResult></SendMessageBatchResponse>`)
+	case "DeleteMessage":
+		fs.delete(queue, form("ReceiptHandle"))
+		fmt.Fprint(w, `<DeleteMessageResponse></DeleteMessageResponse>`)
+	case "DeleteMessageBatch":
+		fmt.Fprint(w, `<DeleteMessageBatchResponse><DeleteMessageBatchResult>`)
+		for i := 1; form(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.Id", i)) != ""; i++ {
+			id := form(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.Id", i))
+			if fs.delete(queue, form(fmt.Sprintf("DeleteMessageBatchRequestEntry.%d.ReceiptHandle", i))) {
+				fmt.Fprintf(w, `<DeleteMessageBatchResultEntry><Id>%s</Id></DeleteMessageBatchResultEntry>`, id)
+			} else {
+				fmt.Fprintf(w, `<BatchResultErrorEntry><Id>%s</Id><Code>ReceiptHandleIsInvalid</Code>`+
+					`<SenderFault>true</SenderFault><Message>invalid</Message></BatchResultErrorEntry>`, id)
+			}
+		}
+		fmt.Fprint(w, `</DeleteMessageBatchResult></DeleteMessageBatchResponse>`)
+	case "ChangeMessageVisibility":
+		if _, m := fs.find(queue, form("ReceiptHandle")); m != nil {
+			seconds, _ := strconv.Atoi(form("VisibilityTimeout"))
+			m.visibleAt = time.Now().Add(time.Duration(seconds) * time.Second)
+		}
+		fmt.Fprint(w, `<ChangeMessageVisibilityResponse></ChangeMessageVisibilityResponse>`)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+	}
+}
+
+func newFakeSQS() *fakeSQS {
+	fs := &fakeSQS{queues: make(map[string][]*fakeMessage)}
+	fs.Server = httptest.NewServer(http.HandlerFunc(fs.serve))
+	return fs
+}
+
+func (fs *fakeSQS) options() []Option {
+	// requests counted as they're made, the SDK retries none of them
+	return []Option{WithEndpoint(fs.URL), WithStaticCredentials("id", "secret", ""), WithMaxAttempts(1),
+		WithRetries(0)}
+}
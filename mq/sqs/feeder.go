@@ -0,0 +1,108 @@
+package sqs
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/samwooo/bolsa/job/feeder"
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/samwooo/bolsa/logging"
+)
+
+//////////////////////////////////////////////////////////////////////////////
+// SQS Feeder IMP, a message is deleted once digested successfully, it is //
+// kept invisible while the job retries it and released when it fails    //
+type FeederImp struct {
+	ctx         context.Context
+	logger      logging.Logger
+	qService    *sqs.SQS
+	qUrl        string
+	qWait       int64
+	qBatch      int64
+	qVisibility int64
+	pending     sync.Map
+	failures    int32 // polls failed in a row
+}
+
+func (f *FeederImp) message(d model.Done) (*sqs.Message, bool) {
+	if v, ok := f.pending.Load(d.Key); ok {
+		msg, ok := v.(*sqs.Message)
+		return msg, ok
+	} else {
+		return nil, false
+	}
+}
+
+func (f *FeederImp) Name() string                    { return "sqs" }
+func (f *FeederImp) Continuous() bool                { return true }
+func (f *FeederImp) DoInit(ch chan model.Done) error { return nil }
+func (f *FeederImp) DoExit(ch chan model.Done) error { return nil }
+func (f *FeederImp) DoWork(ch chan model.Done) error {
+	if msgs, err := poll(f.ctx, f.logger, f.qService, f.qUrl, f.qWait, f.qBatch); err != nil {
+		// e.g. a wrong queue url or credentials fail every poll, 100ms doubling up to 6.4s
+		retry := atomic.AddInt32(&f.failures, 1) - 1
+		if retry > 6 {
+			retry = 6
+		}
+		select {
+		case <-f.ctx.Done():
+		case <-time.After(time.Duration(1<<uint(retry)) * 100 * time.Millisecond):
+		}
+		return err
+	} else {
+		atomic.StoreInt32(&f.failures, 0)
+		for _, msg := range msgs {
+			d := model.NewDone(nil, *msg.Body, nil, 0, *msg.Body, model.KeyFrom(*msg.Body))
+			f.pending.Store(d.Key, msg)
+			ch <- d
+		}
+		return nil
+	}
+}
+func (f *FeederImp) DoRetry(ch chan model.Done, d model.Done) error {
+	if msg, ok := f.message(d); ok && f.qVisibility > 0 {
+		changeVisibility(f.logger, f.qService, f.qUrl, msg, f.qVisibility)
+	}
+	ch <- d
+	return nil
+}
+func (f *FeederImp) DoPush(ch chan model.Done, data interface{}) error {
+	ch <- model.NewDone(nil, data, nil, 0, data, model.KeyFrom(data))
+	return nil
+}
+func (f *FeederImp) DoDigest(d model.Done) error {
+	if msg, ok := f.message(d); !ok {
+		return nil
+	} else {
+		f.pending.Delete(d.Key)
+		if d.E == nil || model.IsDuplicate(d.E) {
+			return ack(f.logger, f.qService, f.qUrl, msg)
+		} else {
+			// visible right away, the queue's redrive policy decides what's next
+			return changeVisibility(f.logger, f.qService, f.qUrl, msg, 0)
+		}
+	}
+}
+
+func NewFeederImp(ctx context.Context, qRegion, qUrl string, qWait, qBatch, qVisibility int64,
+	opts ...Option) (*FeederImp, error) {
+	logger := logging.GetLogger(" ⓠ ")
+	if qService, err := connect(logger, qRegion, opts...); err != nil {
+		return nil, err
+	} else {
+		return &FeederImp{ctx: ctx, logger: logger, qService: qService, qUrl: qUrl,
+			qWait: qWait, qBatch: batchOf(qBatch), qVisibility: qVisibility}, nil
+	}
+}
+
+func NewFeeder(ctx context.Context, name string, workers int, qRegion, qUrl string,
+	qWait, qBatch, qVisibility int64, opts ...Option) (*feeder.Feeder, error) {
+	if f, err := NewFeederImp(ctx, qRegion, qUrl, qWait, qBatch, qVisibility, opts...); err != nil {
+		return nil, err
+	} else {
+		return feeder.NewFeeder(ctx, name, workers, f), nil
+	}
+}
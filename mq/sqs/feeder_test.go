@@ -0,0 +1,70 @@
+package sqs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/job/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFeederDigest(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.push("Q", "Lucy", 0)
+	fs.push("Q", "Lily", 0)
+	f, err := NewFeederImp(context.Background(), "local", fs.url("Q"), 0, 10, 60, fs.options()...)
+	assert.Nil(t, err)
+	ch := make(chan model.Done, 10)
+	assert.Nil(t, f.DoWork(ch))
+	assert.Equal(t, 2, len(ch))
+	lucy, lily := <-ch, <-ch
+
+	// kept invisible while the job retries it
+	assert.Nil(t, f.DoRetry(ch, lily))
+	assert.True(t, fs.invisible("Q", "Lily") > 50*time.Second)
+	assert.Equal(t, lily, <-ch)
+
+	assert.Nil(t, f.DoDigest(lucy))
+	assert.Equal(t, []string{"Lily"}, fs.bodies("Q"))
+	lily.E = errors.New("oops")
+	assert.Nil(t, f.DoDigest(lily))
+	assert.Equal(t, []string{"Lily"}, fs.bodies("Q"))
+	assert.Equal(t, time.Duration(0), fs.invisible("Q", "Lily"))
+}
+
+func TestFeederBacksOff(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.fail = 3
+	fs.push("Q", "Lucy", 0)
+	f, err := NewFeederImp(context.Background(), "local", fs.url("Q"), 0, 10, 0, fs.options()...)
+	assert.Nil(t, err)
+	ch := make(chan model.Done, 10)
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NotNil(t, f.DoWork(ch))
+	}
+	// 100ms, 200ms then 400ms
+	assert.True(t, time.Since(start) >= 700*time.Millisecond)
+	assert.Nil(t, f.DoWork(ch))
+	assert.Equal(t, 1, len(ch))
+}
+
+func TestNewFeeder(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.push("Q", "Lucy", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := NewFeeder(ctx, "sqs", 1, "local", fs.url("Q"), 0, 10, 0, fs.options()...)
+	assert.Nil(t, err)
+	d := <-f.Adapt()
+	assert.Equal(t, "Lucy", d.D)
+	f.Digest(d)
+	cancel()
+	for range f.Adapt() {
+	}
+	assert.Equal(t, 0, len(fs.bodies("Q")))
+}
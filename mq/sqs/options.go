@@ -0,0 +1,79 @@
+package sqs
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+type options struct {
+	endpoint    string
+	credentials *credentials.Credentials
+	timeout     time.Duration
+	attempts    int
+	retries     int // the SDK's default if negative
+}
+
+func (o *options) config(qRegion string) *aws.Config {
+	// a client of its own, the SDK writes into the shared default one, e.g. for AWS_CA_BUNDLE
+	config := &aws.Config{
+		Region:     aws.String(qRegion),
+		LogLevel:   aws.LogLevel(aws.LogOff),
+		HTTPClient: &http.Client{Timeout: o.timeout}}
+	if o.retries >= 0 {
+		config.MaxRetries = aws.Int(o.retries)
+	}
+	if o.endpoint != "" {
+		config.Endpoint = aws.String(o.endpoint)
+	}
+	if o.credentials != nil {
+		config.Credentials = o.credentials
+	}
+	return config
+}
+
+func optionsFrom(opts ...Option) *options {
+	o := &options{attempts: 3, retries: -1}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+type Option func(*options)
+
+// e.g. a local SQS-compatible stub
+func WithEndpoint(url string) Option { return func(o *options) { o.endpoint = url } }
+
+func WithStaticCredentials(id, secret, token string) Option {
+	return func(o *options) { o.credentials = credentials.NewStaticCredentials(id, secret, token) }
+}
+
+// empty filename means the default ~/.aws/credentials
+func WithProfile(filename, profile string) Option {
+	return func(o *options) { o.credentials = credentials.NewSharedCredentials(filename, profile) }
+}
+
+// per request, none by default
+func WithTimeout(timeout time.Duration) Option { return func(o *options) { o.timeout = timeout } }
+
+// retries of the SDK itself per request, the SDK's default otherwise, publishers retry throttling
+// on top of it
+func WithRetries(retries int) Option {
+	return func(o *options) {
+		if retries >= 0 {
+			o.retries = retries
+		}
+	}
+}
+
+// give up connecting after attempts, 3 by default
+func WithMaxAttempts(attempts int) Option {
+	return func(o *options) {
+		if attempts > 0 {
+			o.attempts = attempts
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package sqs
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptionsConfig(t *testing.T) {
+	config := optionsFrom().config("local")
+	// never the shared default client
+	assert.NotNil(t, config.HTTPClient)
+	assert.True(t, config.HTTPClient != http.DefaultClient)
+	assert.True(t, optionsFrom().config("local").HTTPClient != config.HTTPClient)
+	assert.Equal(t, time.Duration(0), config.HTTPClient.Timeout)
+	// the SDK's default
+	assert.Nil(t, config.MaxRetries)
+
+	config = optionsFrom(WithTimeout(time.Second), WithRetries(2)).config("local")
+	assert.Equal(t, time.Second, config.HTTPClient.Timeout)
+	assert.Equal(t, 2, aws.IntValue(config.MaxRetries))
+	assert.Equal(t, 0, aws.IntValue(optionsFrom(WithRetries(0)).config("local").MaxRetries))
+}
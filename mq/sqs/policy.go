@@ -0,0 +1,84 @@
+package sqs
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/samwooo/bolsa/logging"
+)
+
+type Visibility int
+
+const (
+	KeepVisibility    Visibility = iota // visible again once its visibility timeout expires
+	ResetVisibility                     // visible again right away
+	BackoffVisibility                   // visible again after Backoff * 2^(receives-1), up to MaxBackoff
+)
+
+// SQS caps visibility timeout to 12 hours
+const maxVisibility = 12 * time.Hour
+
+/////////////////////////////////////////////////////////////////////////////
+// Failure Policy, the zero value leaves a failed message for redelivery //
+type Policy struct {
+	Visibility  Visibility
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	MaxReceives int    // dead letter once received that many times, 0 never
+	DeadLetter  string // dead letter queue url, empty simply drops the message
+}
+
+func (p Policy) delay(receives int) int64 {
+	limit := p.MaxBackoff
+	if limit <= 0 || limit > maxVisibility {
+		limit = maxVisibility
+	}
+	delay := p.Backoff
+	for i := 1; i < receives && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+	return int64(delay / time.Second)
+}
+
+func (p Policy) fail(logger logging.Logger, qService *sqs.SQS, qUrl string, msg *sqs.Message) error {
+	receives := messageFrom(msg).ReceiveCount
+	if p.MaxReceives > 0 && receives >= p.MaxReceives {
+		return deadLetter(logger, qService, qUrl, p.DeadLetter, msg)
+	}
+	switch p.Visibility {
+	case ResetVisibility:
+		return changeVisibility(logger, qService, qUrl, msg, 0)
+	case BackoffVisibility:
+		return changeVisibility(logger, qService, qUrl, msg, p.delay(receives))
+	default:
+		return nil
+	}
+}
+
+func deadLetter(logger logging.Logger, qService *sqs.SQS, qUrl, dlqUrl string, msg *sqs.Message) error {
+	if dlqUrl == "" {
+		logger.Warnf("drop message ( %s ) without dead letter queue", *msg.Body)
+		return ack(logger, qService, qUrl, msg)
+	}
+	params := &sqs.SendMessageInput{
+		QueueUrl:          aws.String(dlqUrl),
+		MessageBody:       msg.Body,
+		MessageAttributes: msg.MessageAttributes,
+	}
+	// FIFO queues need a group
+	if group, ok := msg.Attributes[sqs.MessageSystemAttributeNameMessageGroupId]; ok {
+		params.MessageGroupId = group
+		params.MessageDeduplicationId = msg.MessageId
+	}
+	if _, err := qService.SendMessage(params); err != nil {
+		logger.Errorf("dead letter ( %s ) failed ( %s )", *msg.Body, err.Error())
+		return err
+	} else {
+		logger.Warnf("dead letter ( %s ) to ( %s )", *msg.Body, dlqUrl)
+		return ack(logger, qService, qUrl, msg)
+	}
+}
@@ -0,0 +1,24 @@
+package sqs
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Visibility: BackoffVisibility, Backoff: time.Second * 10, MaxBackoff: time.Minute}
+	assert.Equal(t, int64(10), p.delay(0))
+	assert.Equal(t, int64(10), p.delay(1))
+	assert.Equal(t, int64(20), p.delay(2))
+	assert.Equal(t, int64(40), p.delay(3))
+	assert.Equal(t, int64(60), p.delay(4))
+	assert.Equal(t, int64(60), p.delay(100))
+}
+
+func TestPolicyDelayWithoutMaxBackoff(t *testing.T) {
+	p := Policy{Visibility: BackoffVisibility, Backoff: time.Hour}
+	assert.Equal(t, int64(3600), p.delay(1))
+	assert.Equal(t, int64(43200), p.delay(10))
+}
@@ -0,0 +1,149 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/samwooo/bolsa/common"
+	"github.com/samwooo/bolsa/logging"
+)
+
+///////////////////////////////////////////////////////////////
+// Publishing, GroupId & DeduplicationId for FIFO queues only //
+type Publishing struct {
+	Body            string
+	Attributes      map[string]*sqs.MessageAttributeValue
+	DelaySeconds    int64
+	GroupId         string
+	DeduplicationId string
+}
+
+func (p Publishing) entry(id int) *sqs.SendMessageBatchRequestEntry {
+	entry := &sqs.SendMessageBatchRequestEntry{
+		Id:                aws.String(strconv.Itoa(id)),
+		MessageBody:       aws.String(p.Body),
+		MessageAttributes: p.Attributes,
+	}
+	if p.DelaySeconds > 0 {
+		entry.DelaySeconds = aws.Int64(p.DelaySeconds)
+	}
+	if p.GroupId != "" {
+		entry.MessageGroupId = aws.String(p.GroupId)
+	}
+	if p.DeduplicationId != "" {
+		entry.MessageDeduplicationId = aws.String(p.DeduplicationId)
+	}
+	return entry
+}
+
+/////////////////////
+// SQS Publisher //
+type Publisher struct {
+	ctx      context.Context
+	logger   logging.Logger
+	qService *sqs.SQS
+	qUrl     string
+	retries  int
+}
+
+// retry f as long as SQS throttles it
+func (p *Publisher) throttled(f func() error) (err error) {
+	for retry := 0; ; retry++ {
+		if err = f(); err == nil || !request.IsErrorThrottle(err) || retry >= p.retries {
+			return
+		}
+		p.logger.Warnf("throttled ( %d, %s )", retry, err.Error())
+		select {
+		case <-p.ctx.Done():
+			return p.ctx.Err()
+		case <-time.After(time.Duration(1<<uint(retry)) * 100 * time.Millisecond):
+		}
+	}
+}
+
+func (p *Publisher) Send(pub Publishing) (id string, err error) {
+	entry := pub.entry(0)
+	params := &sqs.SendMessageInput{
+		QueueUrl:               aws.String(p.qUrl),
+		MessageBody:            entry.MessageBody,
+		MessageAttributes:      entry.MessageAttributes,
+		DelaySeconds:           entry.DelaySeconds,
+		MessageGroupId:         entry.MessageGroupId,
+		MessageDeduplicationId: entry.MessageDeduplicationId,
+	}
+	err = p.throttled(func() error {
+		if resp, err := p.qService.SendMessageWithContext(p.ctx, params); err != nil {
+			return err
+		} else {
+			id = aws.StringValue(resp.MessageId)
+			return nil
+		}
+	})
+	if err != nil {
+		p.logger.Errorf("send ( %s ) failed ( %s )", pub.Body, err.Error())
+	} else {
+		p.logger.Debugf("send ( %s ) succeed ( %s )", pub.Body, id)
+	}
+	return
+}
+
+// ids follow pubs, empty for the ones failed
+func (p *Publisher) SendBatch(pubs []Publishing) (ids []string, err error) {
+	ids = make([]string, len(pubs))
+	var errs []string
+	for start := 0; start < len(pubs); start += maxBatch {
+		end := start + maxBatch
+		if end > len(pubs) {
+			end = len(pubs)
+		}
+		var entries []*sqs.SendMessageBatchRequestEntry
+		for i, pub := range pubs[start:end] {
+			entries = append(entries, pub.entry(i))
+		}
+		params := &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(p.qUrl),
+			Entries:  entries,
+		}
+		var resp *sqs.SendMessageBatchOutput
+		if err := p.throttled(func() (err error) {
+			resp, err = p.qService.SendMessageBatchWithContext(p.ctx, params)
+			return
+		}); err != nil {
+			p.logger.Errorf("send ( %d ) messages failed ( %s )", len(entries), err.Error())
+			errs = append(errs, err.Error())
+			continue
+		}
+		for _, succeed := range resp.Successful {
+			if i, err := strconv.Atoi(aws.StringValue(succeed.Id)); err == nil {
+				ids[start+i] = aws.StringValue(succeed.MessageId)
+			}
+		}
+		for _, failed := range resp.Failed {
+			if i, err := strconv.Atoi(aws.StringValue(failed.Id)); err == nil {
+				p.logger.Errorf("send ( %s ) failed ( %s )", pubs[start+i].Body, aws.StringValue(failed.Message))
+			}
+			errs = append(errs, fmt.Sprintf("( %s, %s )", aws.StringValue(failed.Code),
+				aws.StringValue(failed.Message)))
+		}
+	}
+	return ids, common.ErrorFromString(strings.Join(errs, " | "))
+}
+
+func newPublisher(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string) *Publisher {
+	return &Publisher{ctx: ctx, logger: logger, qService: qService, qUrl: qUrl, retries: 5}
+}
+
+func NewPublisher(ctx context.Context, qRegion, qUrl string, opts ...Option) (*Publisher, error) {
+	logger := logging.GetLogger(" ⓠ ")
+	if qService, err := connect(logger, qRegion, opts...); err != nil {
+		return nil, err
+	} else {
+		return newPublisher(ctx, logger, qService, qUrl), nil
+	}
+}
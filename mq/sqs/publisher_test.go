@@ -0,0 +1,75 @@
+package sqs
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/samwooo/bolsa/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var _ = logging.DefaultLogger("", logging.LogLevelFrom("ERROR"), 100)
+
+func newFakePublisher(t *testing.T, fs *fakeSQS) *Publisher {
+	p, err := NewPublisher(context.Background(), "local", fs.url("queue"), fs.options()...)
+	assert.Nil(t, err)
+	return p
+}
+
+func TestPublisherSend(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.throttle = 2
+	id, err := newFakePublisher(t, fs).Send(Publishing{Body: "Lucy", DelaySeconds: 1})
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", id)
+	assert.Equal(t, 3, fs.requested())
+	assert.Equal(t, []string{"Lucy"}, fs.bodies("queue"))
+	assert.True(t, fs.invisible("queue", "Lucy") > 0)
+}
+
+func TestPublisherSendThrottled(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.throttle = 100
+	p := newFakePublisher(t, fs)
+	p.retries = 1
+	_, err := p.Send(Publishing{Body: "Lucy"})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, fs.requested())
+}
+
+func TestPublisherSendBatch(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	fs.throttle, fs.invalid = 1, "fail"
+	var pubs []Publishing
+	for i := 0; i < 12; i++ {
+		pubs = append(pubs, Publishing{Body: fmt.Sprintf("%d", i), GroupId: "g", DeduplicationId: "d"})
+	}
+	pubs[11].Body = "fail"
+	ids, err := newFakePublisher(t, fs).SendBatch(pubs)
+	assert.NotNil(t, err)
+	assert.Equal(t, len(pubs), len(ids))
+	for i := 0; i < 11; i++ {
+		assert.NotEqual(t, "", ids[i])
+	}
+	assert.Equal(t, "", ids[11])
+	// two batches, the first one throttled once
+	assert.Equal(t, 3, fs.requested())
+	assert.Equal(t, 11, len(fs.bodies("queue")))
+}
+
+func TestPublisherWithOptions(t *testing.T) {
+	fs := newFakeSQS()
+	defer fs.Close()
+	p, err := NewPublisher(context.Background(), "local", fs.url("queue"),
+		WithEndpoint(fs.URL), WithStaticCredentials("id", "secret", ""),
+		WithTimeout(time.Second), WithMaxAttempts(1))
+	assert.Nil(t, err)
+	_, err = p.Send(Publishing{Body: "Lily"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Lily"}, fs.bodies("queue"))
+}
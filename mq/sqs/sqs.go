@@ -3,33 +3,31 @@ package sqs
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/samwooo/bolsa/common"
-	"github.com/samwooo/bolsa/job/model"
 	"github.com/samwooo/bolsa/logging"
 )
 
-func connect(logger logging.Logger, qRegion string) *sqs.SQS {
-	config := &aws.Config{
-		Region:   &qRegion,
-		LogLevel: aws.LogLevel(aws.LogOff)}
-	var err error = nil
-	var s *session.Session = nil
-	retry := 0
-	for {
-		if s, err = session.NewSession(config); err != nil {
+const maxBatch = 10
+
+func connect(logger logging.Logger, qRegion string, opts ...Option) (*sqs.SQS, error) {
+	o := optionsFrom(opts...)
+	config := o.config(qRegion)
+	for retry := 0; retry < o.attempts; retry++ {
+		if s, err := session.NewSession(config); err != nil {
 			logger.Errorf("connect failed ( %d, %s )", retry, err.Error())
-			retry++
-			time.Sleep(common.RandomDuration(retry))
+			time.Sleep(common.RandomDuration(retry + 1))
 		} else {
-			break
+			return sqs.New(s, config), nil
 		}
 	}
-	return sqs.New(s, config)
+	return nil, fmt.Errorf("connect ( %s ) failed after ( %d ) attempts", qRegion, o.attempts)
 }
 
 func ack(logger logging.Logger, qService *sqs.SQS, qUrl string, msg *sqs.Message) error {
@@ -45,58 +43,141 @@ func ack(logger logging.Logger, qService *sqs.SQS, qUrl string, msg *sqs.Message
 	}
 }
 
-func poll(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string, qWait int64) (
-	*sqs.Message, error) {
-	params := &sqs.ReceiveMessageInput{
-		QueueUrl:            aws.String(qUrl),
-		MaxNumberOfMessages: aws.Int64(1),
-		WaitTimeSeconds:     aws.Int64(qWait),
-	}
-	if resp, err := qService.ReceiveMessageWithContext(ctx, params); err != nil {
-		logger.Errorf("poll failed ( %s )", err.Error())
-		return nil, err
+// ReceiveMessage and DeleteMessageBatch take at most 10 messages
+func batchOf(qBatch int64) int64 {
+	if qBatch < 1 {
+		return 1
+	} else if qBatch > maxBatch {
+		return maxBatch
 	} else {
-		if len(resp.Messages) == 1 {
-			return resp.Messages[0], nil
-		}
-		err := fmt.Errorf("( %d ) messages retrieved", len(resp.Messages))
-		logger.Error(err)
-		return nil, err
+		return qBatch
 	}
 }
 
-type MessageHandler func(string) error
-
-func (mh MessageHandler) handle(body string) error { return mh(body) }
+func ackBatch(logger logging.Logger, qService *sqs.SQS, qUrl string, msgs []*sqs.Message) error {
+	var errs []string
+	for start := 0; start < len(msgs); start += maxBatch {
+		end := start + maxBatch
+		if end > len(msgs) {
+			end = len(msgs)
+		}
+		var entries []*sqs.DeleteMessageBatchRequestEntry
+		for i, msg := range msgs[start:end] {
+			entries = append(entries, &sqs.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: msg.ReceiptHandle,
+			})
+		}
+		params := &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(qUrl),
+			Entries:  entries,
+		}
+		if resp, err := qService.DeleteMessageBatch(params); err != nil {
+			logger.Errorf("ack ( %d ) messages failed ( %s )", len(entries), err.Error())
+			errs = append(errs, err.Error())
+		} else {
+			for _, failed := range resp.Failed {
+				if i, err := strconv.Atoi(aws.StringValue(failed.Id)); err == nil {
+					logger.Errorf("ack ( %s ) failed ( %s )", *msgs[start+i].Body, aws.StringValue(failed.Message))
+				}
+				errs = append(errs, aws.StringValue(failed.Message))
+			}
+		}
+	}
+	return common.ErrorFromString(strings.Join(errs, " | "))
+}
 
-func consume(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string, qWait int64,
-	handler MessageHandler) error {
-	if msg, err := poll(ctx, logger, qService, qUrl, qWait); err != nil {
+func changeVisibility(logger logging.Logger, qService *sqs.SQS, qUrl string, msg *sqs.Message,
+	seconds int64) error {
+	params := &sqs.ChangeMessageVisibilityInput{
+		QueueUrl:          aws.String(qUrl),
+		ReceiptHandle:     msg.ReceiptHandle,
+		VisibilityTimeout: aws.Int64(seconds),
+	}
+	if _, err := qService.ChangeMessageVisibility(params); err != nil {
+		logger.Errorf("change visibility ( %s, %d ) failed ( %s )", *msg.Body, seconds, err.Error())
 		return err
 	} else {
-		if err := handler.handle(*msg.Body); err != nil {
-			logger.Errorf("handle message ( %s ) failed ( %s )", *msg.Body, err.Error())
-			ack(logger, qService, qUrl, msg)
-			return err
+		return nil
+	}
+}
+
+func poll(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string, qWait, qBatch int64) (
+	[]*sqs.Message, error) {
+	params := &sqs.ReceiveMessageInput{
+		QueueUrl:              aws.String(qUrl),
+		MaxNumberOfMessages:   aws.Int64(batchOf(qBatch)),
+		WaitTimeSeconds:       aws.Int64(qWait),
+		AttributeNames:        aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+		MessageAttributeNames: aws.StringSlice([]string{sqs.QueueAttributeNameAll}),
+	}
+	if resp, err := qService.ReceiveMessageWithContext(ctx, params); err != nil {
+		if ctx.Err() != nil {
+			logger.Debugf("poll cancelled ( %s )", err.Error())
 		} else {
-			logger.Debugf("handle message ( %s ) succeed", *msg.Body)
-			return ack(logger, qService, qUrl, msg)
+			logger.Errorf("poll failed ( %s )", err.Error())
 		}
+		return nil, err
+	} else {
+		// nothing within qWait is normal for a long poll
+		logger.Debugf("( %d ) messages retrieved", len(resp.Messages))
+		return resp.Messages, nil
 	}
 }
 
-func retrieve(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string, qWait int64,
-	labor model.Labor) error {
-	if msg, err := poll(ctx, logger, qService, qUrl, qWait); err != nil {
-		return err
+////////////////////////////////////////////////////
+// Message, Attributes are the system attributes //
+type Message struct {
+	Id                string
+	Body              string
+	ReceiveCount      int
+	Attributes        map[string]string
+	MessageAttributes map[string]*sqs.MessageAttributeValue
+}
+
+func messageFrom(msg *sqs.Message) *Message {
+	receiveCount, _ := strconv.Atoi(aws.StringValue(msg.Attributes[sqs.MessageSystemAttributeNameApproximateReceiveCount]))
+	return &Message{
+		Id:                aws.StringValue(msg.MessageId),
+		Body:              aws.StringValue(msg.Body),
+		ReceiveCount:      receiveCount,
+		Attributes:        aws.StringValueMap(msg.Attributes),
+		MessageAttributes: msg.MessageAttributes,
+	}
+}
+
+type handler interface{ handle(*Message) error }
+
+type MessageHandler func(string) error
+
+func (mh MessageHandler) handle(m *Message) error { return mh(m.Body) }
+
+type Handler func(*Message) error
+
+func (h Handler) handle(m *Message) error { return h(m) }
+
+func consume(ctx context.Context, logger logging.Logger, qService *sqs.SQS, qUrl string, qWait, qBatch int64,
+	handler handler, policy Policy) (received bool, err error) {
+	if msgs, err := poll(ctx, logger, qService, qUrl, qWait, qBatch); err != nil {
+		return false, err
 	} else {
-		if r, err := labor.Work(*msg.Body); err != nil {
-			logger.Errorf("handle message ( %s ) failed ( %s )", *msg.Body, err.Error())
-			ack(logger, qService, qUrl, msg)
-			return err
-		} else {
-			logger.Debugf("handle message ( %s ) succeed ( %+v )", *msg.Body, r)
-			return ack(logger, qService, qUrl, msg)
+		var errs []string
+		var handled []*sqs.Message
+		for _, msg := range msgs {
+			if err := handler.handle(messageFrom(msg)); err != nil {
+				logger.Errorf("handle message ( %s ) failed ( %s )", *msg.Body, err.Error())
+				errs = append(errs, err.Error())
+				if err := policy.fail(logger, qService, qUrl, msg); err != nil {
+					errs = append(errs, err.Error())
+				}
+			} else {
+				logger.Debugf("handle message ( %s ) succeed", *msg.Body)
+				handled = append(handled, msg)
+			}
+		}
+		if err := ackBatch(logger, qService, qUrl, handled); err != nil {
+			errs = append(errs, err.Error())
 		}
+		return true, common.ErrorFromString(strings.Join(errs, " | "))
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/samwooo/bolsa/job/model"
 	"github.com/samwooo/bolsa/logging"
 )
 
@@ -12,14 +13,20 @@ type task interface{}
 type tasks []task
 
 func runTask(logger logging.Logger, t task, in <-chan []reflect.Value) <-chan []reflect.Value {
-	runTaskOn := func(args []reflect.Value) []reflect.Value {
+	runTaskOn := func(args []reflect.Value) (results []reflect.Value) {
 		fn := reflect.Indirect(reflect.ValueOf(t))
 		if fn.Kind() != reflect.Func {
 			err := fmt.Errorf("task ( %+v ) must be a func", t)
 			logger.Error(err.Error())
 			return []reflect.Value{reflect.ValueOf(err)}
+		} else if err := model.Safely(func() error {
+			results = fn.Call(args)
+			return nil
+		}); err != nil {
+			logger.Errorf("task ( %+v ) panic ( %s )", t, err.Error())
+			return []reflect.Value{reflect.ValueOf(err)}
 		} else {
-			return fn.Call(args)
+			return results
 		}
 	}
 
@@ -5,6 +5,7 @@ import (
 	"strconv"
 	"testing"
 
+	"github.com/samwooo/bolsa/job/model"
 	"github.com/samwooo/bolsa/logging"
 	"github.com/stretchr/testify/assert"
 )
@@ -102,3 +103,20 @@ func TestWaterfallWithCombination(t *testing.T) {
 		}}, 100)
 	assert.Equal(t, []interface{}{"10000100"}, r)
 }
+
+func TestWaterfallWithPanic(t *testing.T) {
+	logging.DefaultLogger("", logging.LogLevelFrom("ERROR"), 100)
+
+	r := Waterfall(logging.GetLogger("waterfall test "), tasks{
+		func(a int) int {
+			return a + 1
+		},
+		func(a int) int {
+			panic("Lucy&Lily")
+		}}, 1)
+	assert.Equal(t, 1, len(r))
+	e, ok := r[0].(*model.Error)
+	assert.Equal(t, true, ok)
+	assert.Equal(t, model.TypePanic, e.T)
+	assert.Equal(t, "✗ panic failed ( Lucy&Lily )", e.Error())
+}